@@ -0,0 +1,236 @@
+package kmeaaaaans
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+
+	"github.com/panjf2000/ants/v2"
+	"gonum.org/v1/gonum/mat"
+)
+
+type balancedKmeans struct {
+	nClusters     uint
+	minSize       uint
+	maxSize       uint
+	tolerance     float64
+	maxIterations uint
+	chunkSize     uint
+	initAlgorithm InitAlgorithm
+	newRand       func() *rand.Rand
+}
+
+var _ Kmeans = (*balancedKmeans)(nil)
+
+type flowEntry struct {
+	cost  float64
+	index int
+}
+
+type flowHeap []flowEntry
+
+func (h flowHeap) Len() int            { return len(h) }
+func (h flowHeap) Less(i, j int) bool  { return h[i].cost < h[j].cost }
+func (h flowHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *flowHeap) Push(x interface{}) { *h = append(*h, x.(flowEntry)) }
+func (h *flowHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// assignBalancedClusters solves the size constrained assignment as a min-cost
+// flow by successive shortest paths. Samples are only visited through per
+// cluster heaps, so each augmentation runs Dijkstra over the clusters alone.
+// classes is left untouched when ctx is done before all samples are assigned.
+func assignBalancedClusters(ctx context.Context, costs *mat.Dense, minSize, maxSize int, classes []uint) error {
+	nSamples, nClusters := costs.Dims()
+	penalty := 0.0
+	if 0 < minSize {
+		penalty = 1.0
+		for i := 0; i < nSamples; i++ {
+			for _, cost := range costs.RawRowView(i) {
+				penalty = math.Max(penalty, 2.0*float64(nSamples)*cost)
+			}
+		}
+	}
+
+	assigned := make([]int, nSamples)
+	unassigned := make([]flowHeap, nClusters)
+	for c := range unassigned {
+		unassigned[c] = make(flowHeap, nSamples)
+		for i := 0; i < nSamples; i++ {
+			unassigned[c][i] = flowEntry{cost: costs.At(i, c), index: i}
+		}
+		heap.Init(&unassigned[c])
+	}
+	for i := range assigned {
+		assigned[i] = -1
+	}
+
+	moves := make([]flowHeap, nClusters*nClusters)
+	assign := func(i, c int) {
+		assigned[i] = c
+		costData := costs.RawRowView(i)
+		for to := 0; to < nClusters; to++ {
+			if to != c {
+				heap.Push(&moves[c*nClusters+to], flowEntry{cost: costData[to] - costData[c], index: i})
+			}
+		}
+	}
+
+	loads := make([]int, nClusters)
+	potentials := make([]float64, nClusters)
+	dist := make([]float64, nClusters)
+	visited := make([]bool, nClusters)
+	prevClusters := make([]int, nClusters)
+	prevSamples := make([]int, nClusters)
+	for n := 0; n < nSamples; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		for c := range dist {
+			h := &unassigned[c]
+			for 0 < h.Len() && 0 <= assigned[(*h)[0].index] {
+				heap.Pop(h)
+			}
+			dist[c] = (*h)[0].cost - potentials[c]
+			prevClusters[c] = -1
+			prevSamples[c] = (*h)[0].index
+			visited[c] = false
+		}
+
+		for l := 0; l < nClusters; l++ {
+			from := -1
+			for c := range dist {
+				if !visited[c] && (from < 0 || dist[c] < dist[from]) {
+					from = c
+				}
+			}
+			visited[from] = true
+
+			for to := 0; to < nClusters; to++ {
+				if visited[to] {
+					continue
+				}
+				h := &moves[from*nClusters+to]
+				for 0 < h.Len() && assigned[(*h)[0].index] != from {
+					heap.Pop(h)
+				}
+				if h.Len() == 0 {
+					continue
+				}
+				d := dist[from] + (*h)[0].cost + potentials[from] - potentials[to]
+				if d < dist[to] {
+					dist[to] = d
+					prevClusters[to] = from
+					prevSamples[to] = (*h)[0].index
+				}
+			}
+		}
+
+		sink := -1
+		sinkCost := math.MaxFloat64
+		for c := range dist {
+			if maxSize <= loads[c] {
+				continue
+			}
+			cost := dist[c] + potentials[c]
+			if minSize <= loads[c] {
+				cost += penalty
+			}
+			if cost < sinkCost {
+				sinkCost = cost
+				sink = c
+			}
+		}
+
+		loads[sink]++
+		for c := sink; 0 <= c; c = prevClusters[c] {
+			assign(prevSamples[c], c)
+		}
+		for c := range potentials {
+			potentials[c] += dist[c]
+		}
+	}
+
+	for i, c := range assigned {
+		classes[i] = uint(c)
+	}
+	return nil
+}
+
+func (k *balancedKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *balancedKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	nSamples, featDim := X.Dims()
+	maxSize := k.maxSize
+	if maxSize == 0 {
+		maxSize = uint(nSamples)
+	}
+	if maxSize < k.minSize {
+		return nil, fmt.Errorf("max cluster size is less than min cluster size: %d < %d", maxSize, k.minSize)
+	}
+	if uint(nSamples) < k.nClusters*k.minSize || k.nClusters*maxSize < uint(nSamples) {
+		return nil, fmt.Errorf("cluster size constraints are infeasible for %d samples", nSamples)
+	}
+
+	nextCentroids := calcInitialCentroids(ctx, X, k.nClusters, k.initAlgorithm, nil, k.newRand())
+	centroids := mat.NewDense(int(k.nClusters), featDim, nil)
+
+	defer ants.Release()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	classes := make([]uint, nSamples)
+	indices := makeSequence(uint(nSamples))
+	nSamplesInCluster := make([]float64, k.nClusters)
+	i := 0
+	for ; i < int(k.maxIterations) && k.tolerance < calcError(centroids, nextCentroids); i++ {
+		centroids, nextCentroids = nextCentroids, centroids
+
+		var costs *mat.Dense
+		if costs, err = calcPairwise(ctx, X, centroids, calcSquaredL2Distance, pool, k.chunkSize); err == nil {
+			err = assignBalancedClusters(ctx, costs, int(k.minSize), int(maxSize), classes)
+		}
+		if err != nil {
+			nextCentroids = centroids
+			break
+		}
+
+		accumulateSamples(X, nextCentroids, nSamplesInCluster, classes, indices, nil)
+		updateLloydCentroids(centroids, nextCentroids, nSamplesInCluster)
+	}
+	converged := err == nil && calcError(centroids, nextCentroids) <= k.tolerance
+	centroids = nextCentroids
+
+	// The labels keep the size constraints, so they come from one more
+	// balanced assignment rather than from the nearest centroids.
+	trained := &trainedKmeans{
+		centroids: centroids,
+		nIter:     uint(i),
+		converged: converged,
+	}
+	if err != nil {
+		return trained, err
+	}
+	costs, err := calcPairwise(ctx, X, centroids, calcSquaredL2Distance, pool, k.chunkSize)
+	if err == nil {
+		err = assignBalancedClusters(ctx, costs, int(k.minSize), int(maxSize), classes)
+	}
+	if err != nil {
+		return trained, err
+	}
+	summarizeLabels(X, nil, trained, classes, indices)
+	return trained, nil
+}
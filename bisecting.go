@@ -0,0 +1,259 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"fmt"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type BisectingCriterion int
+
+const (
+	LargestSSE BisectingCriterion = iota + 1
+	LargestSize
+)
+
+func BisectingCriterionFrom(str string) (BisectingCriterion, error) {
+	switch str {
+	case "sse":
+		return LargestSSE, nil
+	case "size":
+		return LargestSize, nil
+	default:
+		return 0, fmt.Errorf("invalid bisecting criterion: %s", str)
+	}
+}
+
+type BisectingNode struct {
+	Centroid   []float64
+	NSamples   uint
+	SSE        float64
+	Cluster    int
+	SplitOrder int
+	Children   []*BisectingNode
+	indices    []uint
+}
+
+type TrainedBisectingKmeans interface {
+	TrainedKmeans
+	Tree() *BisectingNode
+	Cut(nClusters uint) TrainedKmeans
+}
+
+type bisectingKmeans struct {
+	nClusters uint
+	criterion BisectingCriterion
+	splitter  Kmeans
+}
+
+var _ Kmeans = (*bisectingKmeans)(nil)
+
+type trainedBisectingKmeans struct {
+	*trainedKmeans
+	root *BisectingNode
+}
+
+var _ TrainedBisectingKmeans = (*trainedBisectingKmeans)(nil)
+
+func newBisectingNode(X *mat.Dense, indices []uint) *BisectingNode {
+	centroid, sse := calcMeanAndSSE(X, indices)
+	return &BisectingNode{
+		Centroid:   centroid,
+		NSamples:   uint(len(indices)),
+		SSE:        sse,
+		Cluster:    -1,
+		SplitOrder: -1,
+		indices:    indices,
+	}
+}
+
+func (n *BisectingNode) isLeaf() bool {
+	return len(n.Children) == 0
+}
+
+func (k *bisectingKmeans) score(node *BisectingNode) float64 {
+	switch k.criterion {
+	case LargestSSE:
+		return node.SSE
+	case LargestSize:
+		return float64(node.NSamples)
+	default:
+		panic("invalid bisecting criterion")
+	}
+}
+
+// split reports whether node was split and whether the fit of the splitter
+// converged.
+func (k *bisectingKmeans) split(ctx context.Context, X *mat.Dense, node *BisectingNode) (bool, bool, error) {
+	subX := selectRows(X, node.indices)
+	trained, err := k.splitter.FitContext(ctx, subX)
+	if err != nil {
+		return false, false, err
+	}
+	if nCentroids, _ := trained.Centroids().Dims(); nCentroids != 2 {
+		return false, false, fmt.Errorf("bisecting splitter must produce 2 clusters: got %d", nCentroids)
+	}
+
+	var indices [2][]uint
+	for i, class := range trained.Predict(subX) {
+		indices[class] = append(indices[class], node.indices[i])
+	}
+	if len(indices[0]) == 0 || len(indices[1]) == 0 {
+		return false, trained.Converged(), nil
+	}
+
+	node.Children = []*BisectingNode{
+		newBisectingNode(X, indices[0]),
+		newBisectingNode(X, indices[1]),
+	}
+	return true, trained.Converged(), nil
+}
+
+func (k *bisectingKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+// FitContext keeps the tree grown so far when ctx is done, since every split
+// is complete on its own.
+func (k *bisectingKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	nSamples, _ := X.Dims()
+	root := newBisectingNode(X, makeSequence(uint(nSamples)))
+
+	var fitErr error
+	converged := true
+	nSplits := 0
+	leaves := []*BisectingNode{root}
+	unsplittable := make(map[*BisectingNode]bool)
+	for len(leaves) < int(k.nClusters) {
+		if fitErr = ctx.Err(); fitErr != nil {
+			break
+		}
+
+		target := -1
+		for i, leaf := range leaves {
+			if leaf.NSamples < 2 || unsplittable[leaf] {
+				continue
+			}
+			if target < 0 || k.score(leaves[target]) < k.score(leaf) {
+				target = i
+			}
+		}
+		if target < 0 {
+			break
+		}
+
+		node := leaves[target]
+		ok, splitConverged, err := k.split(ctx, X, node)
+		if err != nil {
+			if ctx.Err() == nil {
+				return nil, err
+			}
+			fitErr = err
+			break
+		}
+		converged = converged && splitConverged
+		if !ok {
+			unsplittable[node] = true
+			continue
+		}
+		node.SplitOrder = nSplits
+		nSplits++
+		leaves = append(append(leaves[:target:target], node.Children...), leaves[target+1:]...)
+	}
+
+	labels := make([]uint, nSamples)
+	for i, leaf := range leaves {
+		leaf.Cluster = i
+		for _, index := range leaf.indices {
+			labels[index] = uint(i)
+		}
+	}
+	walkBisectingTree(root, func(node *BisectingNode) {
+		node.indices = nil
+	})
+
+	return &trainedBisectingKmeans{
+		trainedKmeans: newBisectingTrainedKmeans(leaves, labels, uint(nSplits), converged && fitErr == nil),
+		root:          root,
+	}, fitErr
+}
+
+func newBisectingTrainedKmeans(leaves []*BisectingNode, labels []uint, nSplits uint, converged bool) *trainedKmeans {
+	inertia := 0.0
+	clusterSizes := make([]float64, len(leaves))
+	for i, leaf := range leaves {
+		inertia += leaf.SSE
+		clusterSizes[i] = float64(leaf.NSamples)
+	}
+	return &trainedKmeans{
+		centroids:    makeBisectingCentroids(leaves),
+		inertia:      inertia,
+		nIter:        nSplits,
+		converged:    converged,
+		labels:       labels,
+		clusterSizes: clusterSizes,
+	}
+}
+
+func walkBisectingTree(node *BisectingNode, fn func(node *BisectingNode)) {
+	fn(node)
+	for _, child := range node.Children {
+		walkBisectingTree(child, fn)
+	}
+}
+
+func cutBisectingTree(node *BisectingNode, nSplits int) []*BisectingNode {
+	if node.isLeaf() || nSplits <= node.SplitOrder {
+		return []*BisectingNode{node}
+	}
+
+	var leaves []*BisectingNode
+	for _, child := range node.Children {
+		leaves = append(leaves, cutBisectingTree(child, nSplits)...)
+	}
+	return leaves
+}
+
+func makeBisectingCentroids(leaves []*BisectingNode) *mat.Dense {
+	centroids := mat.NewDense(len(leaves), len(leaves[0].Centroid), nil)
+	for i, leaf := range leaves {
+		centroids.SetRow(i, leaf.Centroid)
+	}
+	return centroids
+}
+
+func copyBisectingTree(node *BisectingNode) *BisectingNode {
+	copied := *node
+	copied.Centroid = append([]float64(nil), node.Centroid...)
+	copied.Children = nil
+	for _, child := range node.Children {
+		copied.Children = append(copied.Children, copyBisectingTree(child))
+	}
+	return &copied
+}
+
+// Tree returns a copy of the tree, so that changing it does not affect Cut.
+func (k *trainedBisectingKmeans) Tree() *BisectingNode {
+	return copyBisectingTree(k.root)
+}
+
+func (k *trainedBisectingKmeans) Cut(nClusters uint) TrainedKmeans {
+	nSplits := int(maxUint(nClusters, 1)) - 1
+	leaves := cutBisectingTree(k.root, nSplits)
+
+	// Every leaf of the full tree falls into exactly one leaf of the cut.
+	clusters := make([]uint, len(k.clusterSizes))
+	for c, leaf := range leaves {
+		walkBisectingTree(leaf, func(node *BisectingNode) {
+			if node.isLeaf() {
+				clusters[node.Cluster] = uint(c)
+			}
+		})
+	}
+	labels := make([]uint, len(k.labels))
+	for i, label := range k.labels {
+		labels[i] = clusters[label]
+	}
+	return newBisectingTrainedKmeans(leaves, labels, uint(minInt(nSplits, int(k.nIter))), k.converged)
+}
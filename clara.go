@@ -0,0 +1,193 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+
+	"github.com/panjf2000/ants/v2"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	claransMinNeighbors = 250
+	claransMaxNeighbors = 2000
+)
+
+type claraKMedoids struct {
+	nClusters     uint
+	nSamplings    uint
+	sampleSize    uint
+	maxIterations uint
+	calcDistance  DistanceFunc
+	newRand       func() *rand.Rand
+}
+
+var _ Kmeans = (*claraKMedoids)(nil)
+
+type claransKMedoids struct {
+	nClusters    uint
+	nLocal       uint
+	maxNeighbors uint
+	calcDistance DistanceFunc
+	newRand      func() *rand.Rand
+}
+
+var _ Kmeans = (*claransKMedoids)(nil)
+
+type medoidsCandidate struct {
+	state *medoidState
+	cost  float64
+}
+
+func makeSampleDissimilarity(X *mat.Dense, calcDistance DistanceFunc) func(i, j int) float64 {
+	return func(i, j int) float64 {
+		return calcDistance(X.RawRowView(i), X.RawRowView(j))
+	}
+}
+
+func selectBestMedoids(candidates []medoidsCandidate) *medoidState {
+	best := 0
+	for i := range candidates {
+		if candidates[i].cost < candidates[best].cost {
+			best = i
+		}
+	}
+	return candidates[best].state
+}
+
+func (k *claraKMedoids) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *claraKMedoids) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	nSamples, _ := X.Dims()
+	if nSamples < int(k.nClusters) {
+		return nil, fmt.Errorf("number of samples is less than number of clusters: %d < %d", nSamples, k.nClusters)
+	}
+	sampleSize := k.sampleSize
+	if sampleSize == 0 {
+		sampleSize = 40 + 2*k.nClusters
+	}
+	sampleSize = maxUint(minUint(sampleSize, uint(nSamples)), k.nClusters)
+
+	defer ants.Release()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	dissimilarity := makeSampleDissimilarity(X, k.calcDistance)
+	candidates := make([]medoidsCandidate, maxUint(k.nSamplings, 1))
+	rng := k.newRand()
+	nSubmitted := 0
+	var wg sync.WaitGroup
+	for i := range candidates {
+		// The first sampling always runs, so that a cancelled fit still
+		// returns medoids.
+		if 0 < i && ctx.Err() != nil {
+			break
+		}
+		i := i
+		nSubmitted++
+		indices := rng.Perm(nSamples)[:sampleSize]
+		wg.Add(1)
+		pool.Submit(func() {
+			defer wg.Done()
+			D := mat.NewDense(len(indices), len(indices), nil)
+			for a := range indices {
+				for b := a + 1; b < len(indices); b++ {
+					dist := dissimilarity(indices[a], indices[b])
+					D.Set(a, b, dist)
+					D.Set(b, a, dist)
+				}
+			}
+
+			s, _ := fasterPAM(ctx, D, buildInitialMedoids(D, int(k.nClusters)), k.maxIterations)
+			medoids := make([]int, len(s.medoids))
+			for j, m := range s.medoids {
+				medoids[j] = indices[m]
+			}
+			state := newMedoidState(nSamples, dissimilarity, medoids)
+			state.nIter, state.converged = s.nIter, s.converged
+			candidates[i] = medoidsCandidate{
+				state: state,
+				cost:  state.cost(),
+			}
+		})
+	}
+	wg.Wait()
+
+	return newTrainedKMedoids(X, selectBestMedoids(candidates[:nSubmitted]), k.calcDistance, false), ctx.Err()
+}
+
+func (k *claransKMedoids) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *claransKMedoids) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	nSamples, _ := X.Dims()
+	if nSamples < int(k.nClusters) {
+		return nil, fmt.Errorf("number of samples is less than number of clusters: %d < %d", nSamples, k.nClusters)
+	}
+	maxNeighbors := k.maxNeighbors
+	if maxNeighbors == 0 {
+		// Every neighbor costs a pass over X, so the 1.25% of K(N-K) suggested
+		// by Ng and Han is capped for large N.
+		maxNeighbors = uint(math.Min(math.Max(claransMinNeighbors, 0.0125*float64(k.nClusters)*float64(uint(nSamples)-k.nClusters)), claransMaxNeighbors))
+	}
+
+	defer ants.Release()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	dissimilarity := makeSampleDissimilarity(X, k.calcDistance)
+	candidates := make([]medoidsCandidate, maxUint(k.nLocal, 1))
+	newRand := derivedRand(k.newRand())
+	nSubmitted := 0
+	var wg sync.WaitGroup
+	for i := range candidates {
+		if 0 < i && ctx.Err() != nil {
+			break
+		}
+		i := i
+		nSubmitted++
+		rng := newRand()
+		wg.Add(1)
+		pool.Submit(func() {
+			defer wg.Done()
+			s := newMedoidState(nSamples, dissimilarity, rng.Perm(nSamples)[:k.nClusters])
+			nNeighbors := uint(0)
+			for nNeighbors < maxNeighbors && int(k.nClusters) < nSamples && ctx.Err() == nil {
+				index := rng.Intn(int(k.nClusters))
+				candidate := rng.Intn(nSamples)
+				if s.isMedoidSample[candidate] {
+					continue
+				}
+
+				if s.swapDeltaWith(index, candidate) < 0.0 {
+					s.swap(index, candidate)
+					s.nIter++
+					nNeighbors = 0
+				} else {
+					nNeighbors++
+				}
+			}
+			s.converged = maxNeighbors <= nNeighbors || nSamples <= int(k.nClusters)
+			candidates[i] = medoidsCandidate{
+				state: s,
+				cost:  s.cost(),
+			}
+		})
+	}
+	wg.Wait()
+
+	return newTrainedKMedoids(X, selectBestMedoids(candidates[:nSubmitted]), k.calcDistance, false), ctx.Err()
+}
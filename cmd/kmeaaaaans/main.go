@@ -98,8 +98,46 @@ func readFeatures(r io.Reader, delimiter string) (*mat.Dense, error) {
 	return X, nil
 }
 
+type delimitedRowIterator struct {
+	scanner   *bufio.Scanner
+	delimiter string
+}
+
+func newDelimitedRowIterator(r io.Reader, delimiter string) *delimitedRowIterator {
+	return &delimitedRowIterator{
+		scanner:   bufio.NewScanner(r),
+		delimiter: delimiter,
+	}
+}
+
+func (it *delimitedRowIterator) Next() ([]float64, error) {
+	if !it.scanner.Scan() {
+		if err := it.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	return parseFromSeparateFloat64(it.scanner.Text(), it.delimiter)
+}
+
+func makeOptions(c *cli.Context) []kmeaaaaans.Option {
+	opts := []kmeaaaaans.Option{
+		kmeaaaaans.WithNInit(c.Uint("n-init")),
+		kmeaaaaans.WithReassignmentRatio(c.Float64("reassignment-ratio")),
+	}
+	if seed := c.Int64("seed"); seed != 0 {
+		opts = append(opts, kmeaaaaans.WithSeed(seed))
+	}
+	return opts
+}
+
+func printProgress(stats kmeaaaaans.IterationStats) bool {
+	fmt.Fprintf(os.Stderr, "run %d, iteration %d: inertia %g, shift %g\n", stats.Run, stats.Iteration, stats.Inertia, stats.Shift)
+	return true
+}
+
 func trainAction(c *cli.Context) error {
-	nClusters := c.Uint("clusters")
+	clusters := c.String("clusters")
 	tolerance := c.Float64("tolerance")
 	maxIter := c.Uint("max-iter")
 	maxNoImprove := c.Uint("max-no-improve")
@@ -113,13 +151,44 @@ func trainAction(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	opts := makeOptions(c)
+	if c.Bool("verbose") {
+		opts = append(opts, kmeaaaaans.WithObserver(printProgress))
+	}
+
+	if c.Bool("streaming") {
+		nClusters, err := strconv.ParseUint(clusters, 10, 0)
+		if err != nil {
+			return fmt.Errorf("invalid number of clusters for streaming: %s", clusters)
+		}
+		kmeans := kmeaaaaans.NewStreamingKmeans(uint(nClusters), c.Uint("coreset-size"), tolerance, maxIter, batchSize, initAlgorithm, opts...)
+		trained, err := kmeans.FitStream(newDelimitedRowIterator(os.Stdin, delimiter))
+		if err != nil {
+			return err
+		}
+		return dumpAsSeparatedFloat64(os.Stdout, trained.Centroids(), delimiter)
+	}
 
 	var kmeans kmeaaaaans.Kmeans
-	switch updateAlgorithm {
-	case kmeaaaaans.Lloyd:
-		kmeans = kmeaaaaans.NewLloydKmeans(nClusters, tolerance, maxIter, batchSize, initAlgorithm)
-	case kmeaaaaans.MiniBatch:
-		kmeans = kmeaaaaans.NewMiniBatchKmeans(nClusters, tolerance, maxIter, maxNoImprove, batchSize, initAlgorithm)
+	if clusters == "auto" {
+		kmeans = kmeaaaaans.NewXMeans(c.Uint("min-clusters"), c.Uint("max-clusters"), tolerance, maxIter, batchSize, initAlgorithm, opts...)
+	} else {
+		nClusters, err := strconv.ParseUint(clusters, 10, 0)
+		if err != nil {
+			return fmt.Errorf("invalid number of clusters: %s", clusters)
+		}
+		switch updateAlgorithm {
+		case kmeaaaaans.Lloyd:
+			kmeans = kmeaaaaans.NewLloydKmeans(uint(nClusters), tolerance, maxIter, batchSize, initAlgorithm, opts...)
+		case kmeaaaaans.MiniBatch:
+			kmeans = kmeaaaaans.NewMiniBatchKmeans(uint(nClusters), tolerance, maxIter, maxNoImprove, batchSize, initAlgorithm, opts...)
+		case kmeaaaaans.Elkan:
+			kmeans = kmeaaaaans.NewElkanKmeans(uint(nClusters), tolerance, maxIter, batchSize, initAlgorithm, opts...)
+		case kmeaaaaans.Hamerly:
+			kmeans = kmeaaaaans.NewHamerlyKmeans(uint(nClusters), tolerance, maxIter, batchSize, initAlgorithm, opts...)
+		case kmeaaaaans.Yinyang:
+			kmeans = kmeaaaaans.NewYinyangKmeans(uint(nClusters), tolerance, maxIter, batchSize, initAlgorithm, opts...)
+		}
 	}
 
 	X, err := readFeatures(os.Stdin, delimiter)
@@ -189,13 +258,20 @@ func benchmarkAction(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	opts := makeOptions(c)
 
 	var kmeans kmeaaaaans.Kmeans
 	switch updateAlgorithm {
 	case kmeaaaaans.Lloyd:
-		kmeans = kmeaaaaans.NewLloydKmeans(nClusters, tolerance, maxIter, batchSize, initAlgorithm)
+		kmeans = kmeaaaaans.NewLloydKmeans(nClusters, tolerance, maxIter, batchSize, initAlgorithm, opts...)
 	case kmeaaaaans.MiniBatch:
-		kmeans = kmeaaaaans.NewMiniBatchKmeans(nClusters, tolerance, maxIter, maxNoImprove, batchSize, initAlgorithm)
+		kmeans = kmeaaaaans.NewMiniBatchKmeans(nClusters, tolerance, maxIter, maxNoImprove, batchSize, initAlgorithm, opts...)
+	case kmeaaaaans.Elkan:
+		kmeans = kmeaaaaans.NewElkanKmeans(nClusters, tolerance, maxIter, batchSize, initAlgorithm, opts...)
+	case kmeaaaaans.Hamerly:
+		kmeans = kmeaaaaans.NewHamerlyKmeans(nClusters, tolerance, maxIter, batchSize, initAlgorithm, opts...)
+	case kmeaaaaans.Yinyang:
+		kmeans = kmeaaaaans.NewYinyangKmeans(nClusters, tolerance, maxIter, batchSize, initAlgorithm, opts...)
 	}
 	X, err := readFeatures(os.Stdin, delimiter)
 	if err != nil {
@@ -238,12 +314,38 @@ func main() {
 				UsageText: "kmeaaaaans train [command options]",
 				Action:    trainAction,
 				Flags: []cli.Flag{
-					&cli.UintFlag{
+					&cli.StringFlag{
 						Name:        "clusters",
-						Usage:       "number of clusters",
-						Value:       8,
+						Usage:       "number of clusters, or auto to select it by x-means",
+						Value:       "8",
 						DefaultText: "8",
 					},
+					&cli.UintFlag{
+						Name:        "min-clusters",
+						Usage:       "min number of clusters for auto",
+						Value:       1,
+						DefaultText: "1",
+					},
+					&cli.UintFlag{
+						Name:        "max-clusters",
+						Usage:       "max number of clusters for auto",
+						Value:       20,
+						DefaultText: "20",
+					},
+					&cli.BoolFlag{
+						Name:  "streaming",
+						Usage: "read samples row by row and cluster a bounded coreset",
+					},
+					&cli.BoolFlag{
+						Name:  "verbose",
+						Usage: "print the progress of every iteration to stderr",
+					},
+					&cli.UintFlag{
+						Name:        "coreset-size",
+						Usage:       "number of coreset points kept per level when streaming, 0 means 200 * clusters",
+						Value:       0,
+						DefaultText: "0",
+					},
 					&cli.UintFlag{
 						Name:        "max-iter",
 						Usage:       "max number of iterations",
@@ -256,6 +358,24 @@ func main() {
 						Value:       10,
 						DefaultText: "10",
 					},
+					&cli.UintFlag{
+						Name:        "n-init",
+						Usage:       "number of restarts keeping the lowest inertia",
+						Value:       1,
+						DefaultText: "1",
+					},
+					&cli.Float64Flag{
+						Name:        "reassignment-ratio",
+						Usage:       "reassign mini-batch centroids whose counts fall below this ratio of the largest, 0 to disable",
+						Value:       0.0,
+						DefaultText: "0",
+					},
+					&cli.Int64Flag{
+						Name:        "seed",
+						Usage:       "seed of the random source, 0 for a random seed",
+						Value:       0,
+						DefaultText: "0",
+					},
 					&cli.Float64Flag{
 						Name:        "tolerance",
 						Usage:       "tolerance",
@@ -270,13 +390,13 @@ func main() {
 					},
 					&cli.StringFlag{
 						Name:        "init-algorithm",
-						Usage:       "initialization algorithm",
+						Usage:       "initialization algorithm (kmeans++, greedy-kmeans++, kmeans|| or random)",
 						Value:       "kmeans++",
 						DefaultText: "kmeans++",
 					},
 					&cli.StringFlag{
 						Name:        "update-algorithm",
-						Usage:       "update algorithm",
+						Usage:       "update algorithm (lloyd, mini-batch, elkan, hamerly or yinyang)",
 						Value:       "lloyd",
 						DefaultText: "lloyd",
 					},
@@ -326,6 +446,24 @@ func main() {
 						Value:       10,
 						DefaultText: "10",
 					},
+					&cli.UintFlag{
+						Name:        "n-init",
+						Usage:       "number of restarts keeping the lowest inertia",
+						Value:       1,
+						DefaultText: "1",
+					},
+					&cli.Float64Flag{
+						Name:        "reassignment-ratio",
+						Usage:       "reassign mini-batch centroids whose counts fall below this ratio of the largest, 0 to disable",
+						Value:       0.0,
+						DefaultText: "0",
+					},
+					&cli.Int64Flag{
+						Name:        "seed",
+						Usage:       "seed of the random source, 0 for a random seed",
+						Value:       0,
+						DefaultText: "0",
+					},
 					&cli.Float64Flag{
 						Name:        "tolerance",
 						Usage:       "tolerance",
@@ -340,13 +478,13 @@ func main() {
 					},
 					&cli.StringFlag{
 						Name:        "init-algorithm",
-						Usage:       "initialization algorithm",
+						Usage:       "initialization algorithm (kmeans++, greedy-kmeans++, kmeans|| or random)",
 						Value:       "kmeans++",
 						DefaultText: "kmeans++",
 					},
 					&cli.StringFlag{
 						Name:        "update-algorithm",
-						Usage:       "update algorithm",
+						Usage:       "update algorithm (lloyd, mini-batch, elkan, hamerly or yinyang)",
 						Value:       "lloyd",
 						DefaultText: "lloyd",
 					},
@@ -0,0 +1,226 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+
+	"github.com/panjf2000/ants/v2"
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+type IndexPair struct {
+	I uint
+	J uint
+}
+
+type copKmeans struct {
+	nClusters     uint
+	tolerance     float64
+	maxIterations uint
+	chunkSize     uint
+	initAlgorithm InitAlgorithm
+	mustLink      []IndexPair
+	cannotLink    []IndexPair
+	newRand       func() *rand.Rand
+}
+
+var _ Kmeans = (*copKmeans)(nil)
+
+type linkComponents struct {
+	members    [][]uint
+	cannotLink [][]int
+	order      []int
+}
+
+func findRoot(parents []int, i int) int {
+	for parents[i] != i {
+		parents[i] = parents[parents[i]]
+		i = parents[i]
+	}
+	return i
+}
+
+func makeLinkComponents(nSamples int, mustLink, cannotLink []IndexPair) (*linkComponents, error) {
+	parents := make([]int, nSamples)
+	for i := range parents {
+		parents[i] = i
+	}
+	for _, pairs := range [][]IndexPair{mustLink, cannotLink} {
+		for _, p := range pairs {
+			if nSamples <= int(p.I) || nSamples <= int(p.J) {
+				return nil, fmt.Errorf("constraint index is out of range: (%d, %d)", p.I, p.J)
+			}
+		}
+	}
+	for _, p := range mustLink {
+		parents[findRoot(parents, int(p.I))] = findRoot(parents, int(p.J))
+	}
+
+	componentOf := make([]int, nSamples)
+	roots := make(map[int]int)
+	c := &linkComponents{}
+	for i := range parents {
+		root := findRoot(parents, i)
+		component, ok := roots[root]
+		if !ok {
+			component = len(c.members)
+			roots[root] = component
+			c.members = append(c.members, nil)
+		}
+		componentOf[i] = component
+		c.members[component] = append(c.members[component], uint(i))
+	}
+
+	c.cannotLink = make([][]int, len(c.members))
+	for _, p := range cannotLink {
+		a, b := componentOf[p.I], componentOf[p.J]
+		if a == b {
+			return nil, fmt.Errorf("cannot-link pair is also must-linked: (%d, %d)", p.I, p.J)
+		}
+		c.cannotLink[a] = append(c.cannotLink[a], b)
+		c.cannotLink[b] = append(c.cannotLink[b], a)
+	}
+
+	c.order = make([]int, len(c.members))
+	for i := range c.order {
+		c.order[i] = i
+	}
+	sort.SliceStable(c.order, func(a, b int) bool {
+		ca, cb := c.order[a], c.order[b]
+		if len(c.cannotLink[ca]) != len(c.cannotLink[cb]) {
+			return len(c.cannotLink[cb]) < len(c.cannotLink[ca])
+		}
+		return len(c.members[cb]) < len(c.members[ca])
+	})
+	return c, nil
+}
+
+// assignComponents places the components in order, each on the cheapest
+// cluster which none of its cannot-linked components already occupies. When
+// a component has no such cluster left, the search backtracks to the
+// components placed before it and tries their next cheapest clusters, so an
+// error is only returned when the cannot-links cannot be satisfied at all.
+// The search may take exponential time for dense infeasible constraints.
+func assignComponents(costs *mat.Dense, components *linkComponents, classes []uint) error {
+	_, nClusters := costs.Dims()
+	assigned := make([]int, len(components.members))
+	choices := make([][]int, len(components.members))
+	componentCosts := make([]float64, nClusters)
+	for component, members := range components.members {
+		assigned[component] = -1
+		for j := range componentCosts {
+			componentCosts[j] = 0.0
+		}
+		for _, i := range members {
+			floats.Add(componentCosts, costs.RawRowView(int(i)))
+		}
+		choices[component] = make([]int, nClusters)
+		for j := range choices[component] {
+			choices[component][j] = j
+		}
+		sort.SliceStable(choices[component], func(a, b int) bool {
+			return componentCosts[choices[component][a]] < componentCosts[choices[component][b]]
+		})
+	}
+
+	allowed := func(component, class int) bool {
+		for _, other := range components.cannotLink[component] {
+			if assigned[other] == class {
+				return false
+			}
+		}
+		return true
+	}
+	next := make([]int, len(components.order))
+	for n := 0; n < len(components.order); {
+		component := components.order[n]
+		assigned[component] = -1
+		for next[n] < nClusters && !allowed(component, choices[component][next[n]]) {
+			next[n]++
+		}
+		if next[n] == nClusters {
+			next[n] = 0
+			if n == 0 {
+				return fmt.Errorf("cannot-link constraints cannot be satisfied with %d clusters", nClusters)
+			}
+			n--
+			next[n]++
+			continue
+		}
+		assigned[component] = choices[component][next[n]]
+		n++
+	}
+
+	for component, members := range components.members {
+		for _, i := range members {
+			classes[i] = uint(assigned[component])
+		}
+	}
+	return nil
+}
+
+func (k *copKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *copKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	nSamples, featDim := X.Dims()
+	components, err := makeLinkComponents(nSamples, k.mustLink, k.cannotLink)
+	if err != nil {
+		return nil, err
+	}
+
+	nextCentroids := calcInitialCentroids(ctx, X, k.nClusters, k.initAlgorithm, nil, k.newRand())
+	centroids := mat.NewDense(int(k.nClusters), featDim, nil)
+
+	defer ants.Release()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	classes := make([]uint, nSamples)
+	indices := makeSequence(uint(nSamples))
+	nSamplesInCluster := make([]float64, k.nClusters)
+	i := 0
+	for ; i < int(k.maxIterations) && k.tolerance < calcError(centroids, nextCentroids); i++ {
+		centroids, nextCentroids = nextCentroids, centroids
+
+		var costs *mat.Dense
+		if costs, err = calcPairwise(ctx, X, centroids, calcSquaredL2Distance, pool, k.chunkSize); err != nil {
+			nextCentroids = centroids
+			break
+		}
+		if err = assignComponents(costs, components, classes); err != nil {
+			return &trainedKmeans{centroids: centroids, nIter: uint(i)}, err
+		}
+
+		accumulateSamples(X, nextCentroids, nSamplesInCluster, classes, indices, nil)
+		updateLloydCentroids(centroids, nextCentroids, nSamplesInCluster)
+	}
+	converged := err == nil && calcError(centroids, nextCentroids) <= k.tolerance
+	centroids = nextCentroids
+
+	trained := &trainedKmeans{
+		centroids: centroids,
+		nIter:     uint(i),
+		converged: converged,
+	}
+	if err != nil {
+		return trained, err
+	}
+	costs, err := calcPairwise(ctx, X, centroids, calcSquaredL2Distance, pool, k.chunkSize)
+	if err == nil {
+		err = assignComponents(costs, components, classes)
+	}
+	if err != nil {
+		return trained, err
+	}
+	summarizeLabels(X, nil, trained, classes, indices)
+	return trained, nil
+}
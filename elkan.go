@@ -0,0 +1,128 @@
+package kmeaaaaans
+
+import (
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type elkanAssigner struct {
+	nClusters             int
+	upper                 []float64
+	lower                 []float64
+	halfCentroidDistances []float64
+	halfMinDistances      []float64
+	shifts                []float64
+	hasBounds             bool
+}
+
+var _ centroidAssigner = (*elkanAssigner)(nil)
+
+func newElkanAssigner(nSamples, nClusters int, rng *rand.Rand) centroidAssigner {
+	return &elkanAssigner{
+		nClusters:             nClusters,
+		upper:                 make([]float64, nSamples),
+		lower:                 make([]float64, nSamples*nClusters),
+		halfCentroidDistances: make([]float64, nClusters*nClusters),
+		halfMinDistances:      make([]float64, nClusters),
+		shifts:                make([]float64, nClusters),
+	}
+}
+
+func calcHalfCentroidDistances(centroids *mat.Dense, halfCentroidDistances, halfMinDistances []float64) {
+	nClusters, _ := centroids.Dims()
+	for j := 0; j < nClusters; j++ {
+		halfMinDistances[j] = math.MaxFloat64
+	}
+	for j := 0; j < nClusters; j++ {
+		for l := j + 1; l < nClusters; l++ {
+			dist := 0.5 * calcL2Distance(centroids.RawRowView(j), centroids.RawRowView(l))
+			if halfCentroidDistances != nil {
+				halfCentroidDistances[j*nClusters+l] = dist
+				halfCentroidDistances[l*nClusters+j] = dist
+			}
+			halfMinDistances[j] = math.Min(halfMinDistances[j], dist)
+			halfMinDistances[l] = math.Min(halfMinDistances[l], dist)
+		}
+	}
+}
+
+func calcCentroidShifts(centroids, prevCentroids *mat.Dense, shifts []float64) {
+	for j := range shifts {
+		shifts[j] = calcL2Distance(centroids.RawRowView(j), prevCentroids.RawRowView(j))
+	}
+}
+
+func (a *elkanAssigner) prepare(centroids, prevCentroids *mat.Dense) {
+	calcHalfCentroidDistances(centroids, a.halfCentroidDistances, a.halfMinDistances)
+	a.hasBounds = prevCentroids != nil
+	if a.hasBounds {
+		calcCentroidShifts(centroids, prevCentroids, a.shifts)
+	}
+}
+
+func (a *elkanAssigner) assign(X, centroids *mat.Dense, classes []uint, indices []uint) {
+	if !a.hasBounds {
+		a.initBounds(X, centroids, classes, indices)
+		return
+	}
+
+	for _, i := range indices {
+		featData := X.RawRowView(int(i))
+		lower := a.lower[int(i)*a.nClusters : (int(i)+1)*a.nClusters]
+		for j := range lower {
+			lower[j] = math.Max(0.0, lower[j]-a.shifts[j])
+		}
+
+		class := int(classes[i])
+		upper := a.upper[i] + a.shifts[class]
+		if upper < a.halfMinDistances[class] {
+			a.upper[i] = upper
+			continue
+		}
+
+		tight := false
+		for j := 0; j < a.nClusters; j++ {
+			if j == class || upper < lower[j] || upper < a.halfCentroidDistances[class*a.nClusters+j] {
+				continue
+			}
+			if !tight {
+				upper = calcL2Distance(featData, centroids.RawRowView(class))
+				lower[class] = upper
+				tight = true
+				if upper < lower[j] || upper < a.halfCentroidDistances[class*a.nClusters+j] {
+					continue
+				}
+			}
+
+			dist := calcL2Distance(featData, centroids.RawRowView(j))
+			lower[j] = dist
+			if dist < upper || (dist == upper && j < class) {
+				upper = dist
+				class = j
+			}
+		}
+		a.upper[i] = upper
+		classes[i] = uint(class)
+	}
+}
+
+func (a *elkanAssigner) initBounds(X, centroids *mat.Dense, classes []uint, indices []uint) {
+	for _, i := range indices {
+		featData := X.RawRowView(int(i))
+		lower := a.lower[int(i)*a.nClusters : (int(i)+1)*a.nClusters]
+		minDist := math.MaxFloat64
+		minClass := uint(0)
+		for j := 0; j < a.nClusters; j++ {
+			dist := calcL2Distance(featData, centroids.RawRowView(j))
+			lower[j] = dist
+			if dist < minDist {
+				minDist = dist
+				minClass = uint(j)
+			}
+		}
+		a.upper[i] = minDist
+		classes[i] = minClass
+	}
+}
@@ -0,0 +1,163 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+
+	"github.com/panjf2000/ants/v2"
+	"gonum.org/v1/gonum/mat"
+)
+
+type TrainedFuzzyCMeans interface {
+	TrainedKmeans
+	Membership(X *mat.Dense) *mat.Dense
+}
+
+type fuzzyCMeans struct {
+	nClusters     uint
+	fuzzifier     float64
+	tolerance     float64
+	maxIterations uint
+	chunkSize     uint
+	initAlgorithm InitAlgorithm
+	newRand       func() *rand.Rand
+}
+
+var _ Kmeans = (*fuzzyCMeans)(nil)
+
+type trainedFuzzyCMeans struct {
+	*trainedKmeans
+	fuzzifier float64
+}
+
+var _ TrainedFuzzyCMeans = (*trainedFuzzyCMeans)(nil)
+
+func calcMembership(X *mat.Dense, centroids *mat.Dense, fuzzifier float64, indices []uint, membership *mat.Dense) {
+	nClusters, _ := centroids.Dims()
+	exponent := -2.0 / (fuzzifier - 1.0)
+	for _, i := range indices {
+		featData := X.RawRowView(int(i))
+		membershipData := membership.RawRowView(int(i))
+
+		nearest := -1
+		acc := 0.0
+		for j := 0; j < nClusters; j++ {
+			dist := calcL2Distance(featData, centroids.RawRowView(j))
+			if dist == 0.0 {
+				nearest = j
+				break
+			}
+			membershipData[j] = math.Pow(dist, exponent)
+			acc += membershipData[j]
+		}
+
+		if 0 <= nearest {
+			for j := range membershipData {
+				membershipData[j] = 0.0
+			}
+			membershipData[nearest] = 1.0
+			continue
+		}
+		for j := range membershipData {
+			membershipData[j] /= acc
+		}
+	}
+}
+
+func accumulateFuzzySamples(X *mat.Dense, nextCentroids *mat.Dense, membership *mat.Dense, fuzzifier float64, weightsInCluster []float64) {
+	nextCentroids.Zero()
+	for j := range weightsInCluster {
+		weightsInCluster[j] = 0.0
+	}
+
+	nSamples, _ := X.Dims()
+	for i := 0; i < nSamples; i++ {
+		featData := X.RawRowView(i)
+		for j, u := range membership.RawRowView(i) {
+			weight := math.Pow(u, fuzzifier)
+			weightsInCluster[j] += weight
+			centroidData := nextCentroids.RawRowView(j)
+			for l := range featData {
+				centroidData[l] += weight * featData[l]
+			}
+		}
+	}
+}
+
+func updateFuzzyCentroids(centroids, nextCentroids *mat.Dense, weightsInCluster []float64) {
+	for j, weight := range weightsInCluster {
+		if 0.0 < weight {
+			centroidData := nextCentroids.RawRowView(j)
+			for l := range centroidData {
+				centroidData[l] /= weight
+			}
+		} else {
+			nextCentroids.SetRow(j, centroids.RawRowView(j))
+		}
+	}
+}
+
+func (k *fuzzyCMeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *fuzzyCMeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	if k.fuzzifier <= 1.0 {
+		return nil, fmt.Errorf("fuzzifier must be greater than 1: %v", k.fuzzifier)
+	}
+
+	nSamples, featDim := X.Dims()
+	nextCentroids := calcInitialCentroids(ctx, X, k.nClusters, k.initAlgorithm, nil, k.newRand())
+	centroids := mat.NewDense(int(k.nClusters), featDim, nil)
+
+	defer ants.Release()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	membership := mat.NewDense(nSamples, int(k.nClusters), nil)
+	chunks := makeChunks(makeSequence(uint(nSamples)), k.chunkSize)
+	weightsInCluster := make([]float64, k.nClusters)
+	i := 0
+	for ; i < int(k.maxIterations) && k.tolerance < calcError(centroids, nextCentroids); i++ {
+		centroids, nextCentroids = nextCentroids, centroids
+
+		if err = submitChunks(ctx, pool, chunks, func(_ int, chunk []uint) {
+			calcMembership(X, centroids, k.fuzzifier, chunk, membership)
+		}); err != nil {
+			nextCentroids = centroids
+			break
+		}
+
+		accumulateFuzzySamples(X, nextCentroids, membership, k.fuzzifier, weightsInCluster)
+		updateFuzzyCentroids(centroids, nextCentroids, weightsInCluster)
+	}
+	converged := err == nil && calcError(centroids, nextCentroids) <= k.tolerance
+	centroids = nextCentroids
+
+	trained := &trainedKmeans{
+		centroids: centroids,
+		nIter:     uint(i),
+		converged: converged,
+	}
+	if err == nil {
+		err = summarizeFit(ctx, X, nil, trained, pool, k.chunkSize)
+	}
+	return &trainedFuzzyCMeans{
+		trainedKmeans: trained,
+		fuzzifier:     k.fuzzifier,
+	}, err
+}
+
+func (k *trainedFuzzyCMeans) Membership(X *mat.Dense) *mat.Dense {
+	nSamples, _ := X.Dims()
+	nClusters, _ := k.centroids.Dims()
+	membership := mat.NewDense(nSamples, nClusters, nil)
+	calcMembership(X, k.centroids, k.fuzzifier, makeSequence(uint(nSamples)), membership)
+	return membership
+}
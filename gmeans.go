@@ -0,0 +1,166 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+const gmeansMinSamples = 8
+
+type gmeansKmeans struct {
+	minClusters   uint
+	maxClusters   uint
+	significance  float64
+	tolerance     float64
+	maxIterations uint
+	chunkSize     uint
+	initAlgorithm InitAlgorithm
+	newRand       func() *rand.Rand
+	observer      Observer
+}
+
+var _ Kmeans = (*gmeansKmeans)(nil)
+
+// calcAndersonDarlingPValue tests the normality of values whose mean and
+// variance are estimated from the values themselves, following Stephens.
+func calcAndersonDarlingPValue(values []float64) float64 {
+	n := float64(len(values))
+	mean, std := stat.MeanStdDev(values, nil)
+	if std == 0.0 {
+		return 1.0
+	}
+
+	sorted := make([]float64, len(values))
+	for i, v := range values {
+		sorted[i] = distuv.UnitNormal.CDF((v - mean) / std)
+		sorted[i] = math.Min(math.Max(sorted[i], 1e-15), 1.0-1e-15)
+	}
+	sort.Float64s(sorted)
+
+	a2 := -n
+	for i := range sorted {
+		a2 -= float64(2*i+1) * (math.Log(sorted[i]) + math.Log(1.0-sorted[len(sorted)-1-i])) / n
+	}
+	a2 *= 1.0 + 0.75/n + 2.25/(n*n)
+
+	switch {
+	case 0.6 <= a2:
+		return math.Exp(1.2937 - 5.709*a2 + 0.0186*a2*a2)
+	case 0.34 <= a2:
+		return math.Exp(0.9177 - 4.279*a2 - 1.38*a2*a2)
+	case 0.2 <= a2:
+		return 1.0 - math.Exp(-8.318+42.796*a2-59.938*a2*a2)
+	default:
+		return 1.0 - math.Exp(-13.436+101.14*a2-223.73*a2*a2)
+	}
+}
+
+func (k *gmeansKmeans) splitCluster(ctx context.Context, X *mat.Dense, indices []uint, rng *rand.Rand) (*mat.Dense, float64, error) {
+	if len(indices) < gmeansMinSamples {
+		return nil, 0.0, nil
+	}
+
+	_, featDim := X.Dims()
+	subX := selectRows(X, indices)
+	var covariance mat.SymDense
+	stat.CovarianceMatrix(&covariance, subX, nil)
+	var eig mat.EigenSym
+	if ok := eig.Factorize(&covariance, true); !ok {
+		return nil, 0.0, fmt.Errorf("failed to factorize cluster covariance matrix")
+	}
+	values := eig.Values(nil)
+	var vectors mat.Dense
+	eig.VectorsTo(&vectors)
+	principal := values[featDim-1]
+	if principal <= 0.0 {
+		return nil, 0.0, nil
+	}
+
+	mean, _ := calcMeanAndSSE(subX, makeSequence(uint(len(indices))))
+	scale := math.Sqrt(2.0 * principal / math.Pi)
+	initialCentroids := mat.NewDense(2, featDim, nil)
+	for j := 0; j < featDim; j++ {
+		offset := scale * vectors.At(j, featDim-1)
+		initialCentroids.Set(0, j, mean[j]+offset)
+		initialCentroids.Set(1, j, mean[j]-offset)
+	}
+
+	lloyd := &lloydKmeans{
+		tolerance:     k.tolerance,
+		maxIterations: k.maxIterations,
+		chunkSize:     k.chunkSize,
+		newAssigner:   newBruteForceAssigner,
+		newRand:       derivedRand(rng),
+	}
+	trained, err := lloyd.fitFrom(ctx, subX, nil, initialCentroids)
+	if err != nil {
+		return nil, 0.0, err
+	}
+
+	children := trained.Centroids()
+	direction := make([]float64, featDim)
+	norm := 0.0
+	for j := range direction {
+		direction[j] = children.At(0, j) - children.At(1, j)
+		norm += direction[j] * direction[j]
+	}
+	if norm == 0.0 {
+		return nil, 0.0, nil
+	}
+
+	projections := make([]float64, len(indices))
+	for i := range projections {
+		projections[i] = calcDot(subX.RawRowView(i), direction) / norm
+	}
+	pValue := calcAndersonDarlingPValue(projections)
+	if k.significance <= pValue {
+		return nil, 0.0, nil
+	}
+	return children, k.significance - pValue, nil
+}
+
+func (k *gmeansKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *gmeansKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	if k.minClusters == 0 || k.maxClusters < k.minClusters {
+		return nil, fmt.Errorf("invalid cluster range: [%d, %d]", k.minClusters, k.maxClusters)
+	}
+	if k.significance <= 0.0 || 1.0 <= k.significance {
+		return nil, fmt.Errorf("significance must be in (0, 1): %f", k.significance)
+	}
+
+	rng := k.newRand()
+	observer := newSyncObserver(k.observer)
+	lloyd := &lloydKmeans{
+		nClusters:     k.minClusters,
+		tolerance:     k.tolerance,
+		maxIterations: k.maxIterations,
+		chunkSize:     k.chunkSize,
+		initAlgorithm: k.initAlgorithm,
+		newAssigner:   newBruteForceAssigner,
+		newRand:       derivedRand(rng),
+		observer:      observer.asObserver(),
+	}
+	trained, err := lloyd.FitContext(ctx, X)
+	if err == nil {
+		trained, err = fitBySplitting(ctx, X, lloyd, trained, k.maxClusters, observer, func(X *mat.Dense, indices []uint) (*mat.Dense, float64, error) {
+			return k.splitCluster(ctx, X, indices, rng)
+		})
+	}
+	if trained == nil {
+		return nil, err
+	}
+
+	return &trainedXMeans{
+		trainedKmeans: trained.(*trainedKmeans),
+	}, err
+}
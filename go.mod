@@ -2,12 +2,16 @@ module github.com/ar90n/kmeaaaaans
 
 go 1.19
 
+require (
+	github.com/panjf2000/ants/v2 v2.4.7
+	github.com/pkg/profile v1.6.0
+	github.com/urfave/cli/v2 v2.3.0
+	gonum.org/v1/gonum v0.9.3
+)
+
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.0-20190314233015-f79a8a8ca69d // indirect
-	github.com/panjf2000/ants/v2 v2.4.7 // indirect
-	github.com/pkg/profile v1.6.0 // indirect
 	github.com/russross/blackfriday/v2 v2.0.1 // indirect
 	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
-	github.com/urfave/cli/v2 v2.3.0 // indirect
-	gonum.org/v1/gonum v0.9.3 // indirect
+	golang.org/x/exp v0.0.0-20191002040644-a1355ae1e2c3 // indirect
 )
@@ -0,0 +1,96 @@
+package kmeaaaaans
+
+import (
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type hamerlyAssigner struct {
+	upper            []float64
+	lower            []float64
+	halfMinDistances []float64
+	shifts           []float64
+	maxShiftClass    int
+	maxShift         float64
+	secondMaxShift   float64
+	hasBounds        bool
+}
+
+var _ centroidAssigner = (*hamerlyAssigner)(nil)
+
+func newHamerlyAssigner(nSamples, nClusters int, rng *rand.Rand) centroidAssigner {
+	return &hamerlyAssigner{
+		upper:            make([]float64, nSamples),
+		lower:            make([]float64, nSamples),
+		halfMinDistances: make([]float64, nClusters),
+		shifts:           make([]float64, nClusters),
+	}
+}
+
+func (a *hamerlyAssigner) prepare(centroids, prevCentroids *mat.Dense) {
+	calcHalfCentroidDistances(centroids, nil, a.halfMinDistances)
+	a.hasBounds = prevCentroids != nil
+	if !a.hasBounds {
+		return
+	}
+
+	calcCentroidShifts(centroids, prevCentroids, a.shifts)
+	a.maxShiftClass = 0
+	a.maxShift = 0.0
+	a.secondMaxShift = 0.0
+	for j, shift := range a.shifts {
+		if a.maxShift < shift {
+			a.secondMaxShift = a.maxShift
+			a.maxShift = shift
+			a.maxShiftClass = j
+		} else if a.secondMaxShift < shift {
+			a.secondMaxShift = shift
+		}
+	}
+}
+
+func (a *hamerlyAssigner) assign(X, centroids *mat.Dense, classes []uint, indices []uint) {
+	for _, i := range indices {
+		featData := X.RawRowView(int(i))
+		if a.hasBounds {
+			class := int(classes[i])
+			a.upper[i] += a.shifts[class]
+			if class == a.maxShiftClass {
+				a.lower[i] -= a.secondMaxShift
+			} else {
+				a.lower[i] -= a.maxShift
+			}
+
+			bound := math.Max(a.halfMinDistances[class], a.lower[i])
+			if a.upper[i] < bound {
+				continue
+			}
+			a.upper[i] = calcL2Distance(featData, centroids.RawRowView(class))
+			if a.upper[i] < bound {
+				continue
+			}
+		}
+
+		classes[i], a.upper[i], a.lower[i] = findTwoNearestCentroids(featData, centroids)
+	}
+}
+
+func findTwoNearestCentroids(featData []float64, centroids *mat.Dense) (uint, float64, float64) {
+	nClusters, _ := centroids.Dims()
+	minDist := math.MaxFloat64
+	secondMinDist := math.MaxFloat64
+	minClass := uint(0)
+	for j := 0; j < nClusters; j++ {
+		dist := calcL2Distance(featData, centroids.RawRowView(j))
+		if dist < minDist {
+			secondMinDist = minDist
+			minDist = dist
+			minClass = uint(j)
+		} else if dist < secondMinDist {
+			secondMinDist = dist
+		}
+	}
+	return minClass, minDist, secondMinDist
+}
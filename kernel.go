@@ -0,0 +1,345 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+
+	"github.com/panjf2000/ants/v2"
+	"gonum.org/v1/gonum/mat"
+)
+
+type Kernel func(X, Y []float64) float64
+
+func RBFKernel(gamma float64) Kernel {
+	return func(X, Y []float64) float64 {
+		return math.Exp(-gamma * calcSquaredL2Distance(X, Y))
+	}
+}
+
+func PolynomialKernel(degree float64, gamma float64, coef0 float64) Kernel {
+	return func(X, Y []float64) float64 {
+		return math.Pow(gamma*calcDot(X, Y)+coef0, degree)
+	}
+}
+
+type kernelKmeans struct {
+	nClusters     uint
+	tolerance     float64
+	maxIterations uint
+	nComponents   uint
+	chunkSize     uint
+	initAlgorithm InitAlgorithm
+	kernel        Kernel
+	newRand       func() *rand.Rand
+}
+
+var _ Kmeans = (*kernelKmeans)(nil)
+
+type trainedKernelKmeans struct {
+	*trainedKmeans
+	kernel    Kernel
+	supports  *mat.Dense
+	classes   []uint
+	sizes     []uint
+	selfTerms []float64
+}
+
+var _ TrainedKmeans = (*trainedKernelKmeans)(nil)
+
+type trainedNystroemKmeans struct {
+	*trainedKmeans
+	kernel     Kernel
+	landmarks  *mat.Dense
+	projection *mat.Dense
+	features   TrainedKmeans
+}
+
+var _ TrainedKmeans = (*trainedNystroemKmeans)(nil)
+
+func calcClusterMeans(X *mat.Dense, classes []uint, nClusters uint) *mat.Dense {
+	_, featDim := X.Dims()
+	clusterIndices := make([][]uint, nClusters)
+	for i, class := range classes {
+		clusterIndices[class] = append(clusterIndices[class], uint(i))
+	}
+
+	centroids := mat.NewDense(int(nClusters), featDim, nil)
+	for i, indices := range clusterIndices {
+		mean, _ := calcMeanAndSSE(X, indices)
+		centroids.SetRow(i, mean)
+	}
+	return centroids
+}
+
+func calcKernelClusterSums(G *mat.Dense, classes []uint, nClusters uint, indices []uint, sums []float64) {
+	for _, i := range indices {
+		clusterSums := sums[int(i)*int(nClusters) : (int(i)+1)*int(nClusters)]
+		for j := range clusterSums {
+			clusterSums[j] = 0.0
+		}
+		for j, g := range G.RawRowView(int(i)) {
+			clusterSums[classes[j]] += g
+		}
+	}
+}
+
+func calcKernelSelfTerms(sums []float64, classes []uint, sizes []uint, selfTerms []float64) {
+	nClusters := len(sizes)
+	for j := range sizes {
+		sizes[j] = 0
+		selfTerms[j] = 0.0
+	}
+	for i, class := range classes {
+		sizes[class]++
+		selfTerms[class] += sums[i*nClusters+int(class)]
+	}
+	for j, size := range sizes {
+		if 0 < size {
+			selfTerms[j] /= float64(size) * float64(size)
+		}
+	}
+}
+
+func assignKernelCluster(clusterSums []float64, selfSimilarity float64, sizes []uint, selfTerms []float64) (uint, float64) {
+	minDist := math.MaxFloat64
+	minClass := uint(0)
+	for j, size := range sizes {
+		if size == 0 {
+			continue
+		}
+		dist := selfSimilarity - 2.0*clusterSums[j]/float64(size) + selfTerms[j]
+		if dist < minDist {
+			minDist = dist
+			minClass = uint(j)
+		}
+	}
+	return minClass, minDist
+}
+
+// fitCutOff stands in for a fit cut off before its kernel matrix is complete.
+// The initial centroids are returned, which assign the samples by euclidean
+// distance as the exact fit does at first.
+func (k *kernelKmeans) fitCutOff(ctx context.Context, X *mat.Dense, rng *rand.Rand) TrainedKmeans {
+	return &trainedKmeans{
+		centroids: calcInitialCentroids(ctx, X, k.nClusters, k.initAlgorithm, nil, rng),
+	}
+}
+
+func (k *kernelKmeans) fitExact(ctx context.Context, X *mat.Dense, pool *ants.Pool, rng *rand.Rand) (TrainedKmeans, error) {
+	nSamples, _ := X.Dims()
+	G, err := calcPairwise(ctx, X, X, k.kernel, pool, k.chunkSize)
+	if err != nil {
+		return k.fitCutOff(ctx, X, rng), err
+	}
+
+	classes := make([]uint, nSamples)
+	indices := makeSequence(uint(nSamples))
+	chunks := makeChunks(indices, k.chunkSize)
+	assignCluster(X, calcInitialCentroids(ctx, X, k.nClusters, k.initAlgorithm, nil, rng), classes, indices, nil, calcL2Distance)
+
+	sums := make([]float64, nSamples*int(k.nClusters))
+	sizes := make([]uint, k.nClusters)
+	selfTerms := make([]float64, k.nClusters)
+	updateClusterStats := func() {
+		var wg sync.WaitGroup
+		for _, chunk := range chunks {
+			chunk := chunk
+			wg.Add(1)
+			pool.Submit(func() {
+				defer wg.Done()
+				calcKernelClusterSums(G, classes, k.nClusters, chunk, sums)
+			})
+		}
+		wg.Wait()
+		calcKernelSelfTerms(sums, classes, sizes, selfTerms)
+	}
+
+	nextClasses := make([]uint, nSamples)
+	nIter := uint(0)
+	converged := false
+	updateClusterStats()
+	for i := 0; i < int(k.maxIterations); i++ {
+		if err = submitChunks(ctx, pool, chunks, func(_ int, chunk []uint) {
+			for _, l := range chunk {
+				clusterSums := sums[int(l)*int(k.nClusters) : (int(l)+1)*int(k.nClusters)]
+				nextClasses[l], _ = assignKernelCluster(clusterSums, G.At(int(l), int(l)), sizes, selfTerms)
+			}
+		}); err != nil {
+			break
+		}
+		nIter++
+
+		// The exact fit has no centroids to compare, so tolerance is the
+		// fraction of samples which may still change their cluster.
+		nChanged := 0
+		for l := range classes {
+			if classes[l] != nextClasses[l] {
+				nChanged++
+			}
+		}
+		if 0 < nChanged {
+			classes, nextClasses = nextClasses, classes
+			updateClusterStats()
+		}
+		if float64(nChanged) <= k.tolerance*float64(nSamples) {
+			converged = true
+			break
+		}
+	}
+
+	// The inertia is measured in the feature space of the kernel, where the
+	// cluster means are only known through sums and selfTerms.
+	inertia := 0.0
+	clusterSizes := make([]float64, k.nClusters)
+	for l, class := range classes {
+		clusterSums := sums[l*int(k.nClusters) : (l+1)*int(k.nClusters)]
+		inertia += G.At(l, l) - 2.0*clusterSums[class]/float64(sizes[class]) + selfTerms[class]
+		clusterSizes[class]++
+	}
+
+	return &trainedKernelKmeans{
+		trainedKmeans: &trainedKmeans{
+			centroids:    calcClusterMeans(X, classes, k.nClusters),
+			inertia:      inertia,
+			nIter:        nIter,
+			converged:    converged,
+			labels:       append([]uint(nil), classes...),
+			clusterSizes: clusterSizes,
+		},
+		kernel:    k.kernel,
+		supports:  mat.DenseCopyOf(X),
+		classes:   classes,
+		sizes:     sizes,
+		selfTerms: selfTerms,
+	}, err
+}
+
+func (k *kernelKmeans) fitNystroem(ctx context.Context, X *mat.Dense, pool *ants.Pool, rng *rand.Rand) (TrainedKmeans, error) {
+	nSamples, _ := X.Dims()
+	nComponents := minInt(int(k.nComponents), nSamples)
+	landmarkIndices := make([]uint, nComponents)
+	for i, index := range rng.Perm(nSamples)[:nComponents] {
+		landmarkIndices[i] = uint(index)
+	}
+	landmarks := selectRows(X, landmarkIndices)
+
+	Kmm, err := calcPairwise(ctx, landmarks, landmarks, k.kernel, pool, k.chunkSize)
+	if err != nil {
+		return k.fitCutOff(ctx, X, rng), err
+	}
+	var eig mat.EigenSym
+	if ok := eig.Factorize(mat.NewSymDense(nComponents, Kmm.RawMatrix().Data), true); !ok {
+		return nil, fmt.Errorf("failed to factorize landmark kernel matrix")
+	}
+	values := eig.Values(nil)
+	var vectors mat.Dense
+	eig.VectorsTo(&vectors)
+
+	maxValue := 0.0
+	for _, v := range values {
+		maxValue = math.Max(maxValue, v)
+	}
+	var components []int
+	for i, v := range values {
+		if 1e-12*maxValue < v {
+			components = append(components, i)
+		}
+	}
+	if len(components) == 0 {
+		return nil, fmt.Errorf("landmark kernel matrix is not positive definite")
+	}
+	projection := mat.NewDense(nComponents, len(components), nil)
+	for j, c := range components {
+		scale := 1.0 / math.Sqrt(values[c])
+		for i := 0; i < nComponents; i++ {
+			projection.Set(i, j, vectors.At(i, c)*scale)
+		}
+	}
+
+	Knm, err := calcPairwise(ctx, X, landmarks, k.kernel, pool, k.chunkSize)
+	if err != nil {
+		return k.fitCutOff(ctx, X, rng), err
+	}
+	var features mat.Dense
+	features.Mul(Knm, projection)
+	trained, err := NewLloydKmeans(k.nClusters, k.tolerance, k.maxIterations, k.chunkSize, k.initAlgorithm, WithSeed(rng.Int63())).FitContext(ctx, &features)
+	if trained == nil {
+		return nil, err
+	}
+
+	// A cancelled fit carries no labels, which the centroids in the input
+	// space are still computed from.
+	labels := trained.Labels()
+	if labels == nil {
+		labels = trained.Predict(&features)
+	}
+	return &trainedNystroemKmeans{
+		trainedKmeans: &trainedKmeans{
+			centroids:    calcClusterMeans(X, labels, k.nClusters),
+			inertia:      trained.Inertia(),
+			nIter:        trained.NIter(),
+			converged:    trained.Converged(),
+			labels:       trained.Labels(),
+			clusterSizes: trained.ClusterSizes(),
+		},
+		kernel:     k.kernel,
+		landmarks:  landmarks,
+		projection: projection,
+		features:   trained,
+	}, err
+}
+
+func (k *kernelKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *kernelKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	defer ants.Release()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	if 0 < k.nComponents {
+		return k.fitNystroem(ctx, X, pool, k.newRand())
+	}
+	return k.fitExact(ctx, X, pool, k.newRand())
+}
+
+func (k *trainedKernelKmeans) Predict(X *mat.Dense) []uint {
+	nSamples, _ := X.Dims()
+	nClusters := uint(len(k.sizes))
+	classes := make([]uint, nSamples)
+	clusterSums := make([]float64, nClusters)
+	for i := 0; i < nSamples; i++ {
+		featData := X.RawRowView(i)
+		for j := range clusterSums {
+			clusterSums[j] = 0.0
+		}
+		for j, class := range k.classes {
+			clusterSums[class] += k.kernel(featData, k.supports.RawRowView(j))
+		}
+		classes[i], _ = assignKernelCluster(clusterSums, k.kernel(featData, featData), k.sizes, k.selfTerms)
+	}
+	return classes
+}
+
+func (k *trainedNystroemKmeans) Predict(X *mat.Dense) []uint {
+	nSamples, _ := X.Dims()
+	nLandmarks, _ := k.landmarks.Dims()
+	similarities := mat.NewDense(nSamples, nLandmarks, nil)
+	for i := 0; i < nSamples; i++ {
+		for j := 0; j < nLandmarks; j++ {
+			similarities.Set(i, j, k.kernel(X.RawRowView(i), k.landmarks.RawRowView(j)))
+		}
+	}
+
+	var features mat.Dense
+	features.Mul(similarities, k.projection)
+	return k.features.Predict(&features)
+}
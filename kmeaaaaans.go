@@ -1,6 +1,7 @@
 package kmeaaaaans
 
 import (
+	"context"
 	"fmt"
 
 	"gonum.org/v1/gonum/mat"
@@ -11,6 +12,9 @@ type UpdateAlgorithm int
 const (
 	Lloyd UpdateAlgorithm = iota + 1
 	MiniBatch
+	Elkan
+	Hamerly
+	Yinyang
 )
 
 func UpdateAlgorithmFrom(str string) (UpdateAlgorithm, error) {
@@ -19,38 +23,308 @@ func UpdateAlgorithmFrom(str string) (UpdateAlgorithm, error) {
 		return Lloyd, nil
 	case "mini-batch":
 		return MiniBatch, nil
+	case "elkan":
+		return Elkan, nil
+	case "hamerly":
+		return Hamerly, nil
+	case "yinyang":
+		return Yinyang, nil
 	default:
 		return 0, fmt.Errorf("invalid update algorithm: %s", str)
 	}
 }
 
+type DistanceFunc func(X, Y []float64) float64
+
 type Kmeans interface {
 	Fit(X *mat.Dense) (TrainedKmeans, error)
+	FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error)
+}
+
+type WeightedKmeans interface {
+	Kmeans
+	FitWeighted(X *mat.Dense, weights []float64) (TrainedKmeans, error)
 }
 
+// TrainedKmeans also describes the fit. Labels, ClusterSizes and Inertia
+// refer to the training samples, where Inertia is the objective of the model:
+// the weighted sum of squared euclidean distances to the assigned centroids,
+// or of plain distances for models fitted with another distance. A model
+// returned along with the error of a cancelled fit may only carry the
+// centroids reached so far.
 type TrainedKmeans interface {
 	Predict(X *mat.Dense) []uint
 	Centroids() *mat.Dense
+	Inertias() []float64
+	Inertia() float64
+	NIter() uint
+	Converged() bool
+	Labels() []uint
+	ClusterSizes() []float64
 }
 
-func NewMiniBatchKmeans(nClusters uint, tolerance float64, maxIterations uint, maxNoImprobe uint, batchSize uint, initAlgorithm InitAlgorithm) Kmeans {
+func NewMiniBatchKmeans(nClusters uint, tolerance float64, maxIterations uint, maxNoImprobe uint, batchSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
 	return &miniBatchKmeans{
+		tolerance:         tolerance,
+		maxIterations:     maxIterations,
+		maxNoImprobe:      maxNoImprobe,
+		nClusters:         nClusters,
+		batchSize:         batchSize,
+		initAlgorithm:     initAlgorithm,
+		reassignmentRatio: o.reassignmentRatio,
+		nInit:             o.nInit,
+		newRand:           o.newRand,
+		observer:          o.observer,
+	}
+}
+
+func NewLloydKmeans(nClusters uint, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &lloydKmeans{
+		nClusters:     nClusters,
 		tolerance:     tolerance,
 		maxIterations: maxIterations,
-		maxNoImprobe:  maxNoImprobe,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		newAssigner:   newBruteForceAssigner,
+		nInit:         o.nInit,
+		newRand:       o.newRand,
+		observer:      o.observer,
+	}
+}
+
+func NewElkanKmeans(nClusters uint, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &lloydKmeans{
 		nClusters:     nClusters,
-		batchSize:     batchSize,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
 		initAlgorithm: initAlgorithm,
+		newAssigner:   newElkanAssigner,
+		nInit:         o.nInit,
+		newRand:       o.newRand,
+		observer:      o.observer,
 	}
 }
 
-func NewLloydKmeans(nClusters uint, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm) Kmeans {
+func NewHamerlyKmeans(nClusters uint, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
 	return &lloydKmeans{
 		nClusters:     nClusters,
 		tolerance:     tolerance,
 		maxIterations: maxIterations,
 		chunkSize:     chunkSize,
 		initAlgorithm: initAlgorithm,
+		newAssigner:   newHamerlyAssigner,
+		nInit:         o.nInit,
+		newRand:       o.newRand,
+		observer:      o.observer,
+	}
+}
+
+func NewYinyangKmeans(nClusters uint, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &lloydKmeans{
+		nClusters:     nClusters,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		newAssigner:   newYinyangAssigner,
+		nInit:         o.nInit,
+		newRand:       o.newRand,
+		observer:      o.observer,
+	}
+}
+
+func NewBisectingKmeans(nClusters uint, criterion BisectingCriterion, splitter Kmeans) Kmeans {
+	return &bisectingKmeans{
+		nClusters: nClusters,
+		criterion: criterion,
+		splitter:  splitter,
+	}
+}
+
+func NewMedianKmeans(nClusters uint, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &medianKmeans{
+		nClusters:     nClusters,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		newRand:       o.newRand,
+	}
+}
+
+func NewStreamingKmeans(nClusters uint, coresetSize uint, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) StreamingKmeans {
+	o := newOptions(opts)
+	return &streamingKmeans{
+		nClusters:     nClusters,
+		coresetSize:   coresetSize,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		newRand:       o.newRand,
+		observer:      o.observer,
+	}
+}
+
+func NewXMeans(minClusters uint, maxClusters uint, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &xmeansKmeans{
+		minClusters:   minClusters,
+		maxClusters:   maxClusters,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		newRand:       o.newRand,
+		observer:      o.observer,
+	}
+}
+
+func NewGMeans(minClusters uint, maxClusters uint, significance float64, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &gmeansKmeans{
+		minClusters:   minClusters,
+		maxClusters:   maxClusters,
+		significance:  significance,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		newRand:       o.newRand,
+		observer:      o.observer,
+	}
+}
+
+func NewBalancedKmeans(nClusters uint, minSize uint, maxSize uint, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &balancedKmeans{
+		nClusters:     nClusters,
+		minSize:       minSize,
+		maxSize:       maxSize,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		newRand:       o.newRand,
+	}
+}
+
+func NewCOPKmeans(nClusters uint, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, mustLink []IndexPair, cannotLink []IndexPair, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &copKmeans{
+		nClusters:     nClusters,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		mustLink:      mustLink,
+		cannotLink:    cannotLink,
+		newRand:       o.newRand,
+	}
+}
+
+func NewTrimmedKmeans(nClusters uint, trimming float64, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &trimmedKmeans{
+		nClusters:     nClusters,
+		trimming:      trimming,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		newRand:       o.newRand,
+	}
+}
+
+func NewSphericalKmeans(nClusters uint, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &sphericalKmeans{
+		nClusters:     nClusters,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		newRand:       o.newRand,
+	}
+}
+
+// NewKernelKmeans fits exactly on the kernel matrix when nComponents is 0, and
+// then stops once at most a tolerance fraction of the samples change their
+// cluster. Otherwise Lloyd runs on nComponents Nyström features.
+func NewKernelKmeans(nClusters uint, tolerance float64, maxIterations uint, nComponents uint, chunkSize uint, initAlgorithm InitAlgorithm, kernel Kernel, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &kernelKmeans{
+		nClusters:     nClusters,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		nComponents:   nComponents,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		kernel:        kernel,
+		newRand:       o.newRand,
+	}
+}
+
+func NewFuzzyCMeans(nClusters uint, fuzzifier float64, tolerance float64, maxIterations uint, chunkSize uint, initAlgorithm InitAlgorithm, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	return &fuzzyCMeans{
+		nClusters:     nClusters,
+		fuzzifier:     fuzzifier,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
+		initAlgorithm: initAlgorithm,
+		newRand:       o.newRand,
+	}
+}
+
+func NewKMedoids(nClusters uint, maxIterations uint, chunkSize uint, calcDistance DistanceFunc) KMedoids {
+	if calcDistance == nil {
+		calcDistance = calcL2Distance
+	}
+	return &kMedoids{
+		nClusters:     nClusters,
+		maxIterations: maxIterations,
+		chunkSize:     chunkSize,
+		calcDistance:  calcDistance,
+	}
+}
+
+func NewCLARA(nClusters uint, nSamplings uint, sampleSize uint, maxIterations uint, calcDistance DistanceFunc, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	if calcDistance == nil {
+		calcDistance = calcL2Distance
+	}
+	return &claraKMedoids{
+		nClusters:     nClusters,
+		nSamplings:    nSamplings,
+		sampleSize:    sampleSize,
+		maxIterations: maxIterations,
+		calcDistance:  calcDistance,
+		newRand:       o.newRand,
+	}
+}
+
+// NewCLARANS stops each local search after maxNeighbors failed swaps. When it
+// is 0, max(250, 1.25% of K(N-K)) capped at 2000 neighbors is used.
+func NewCLARANS(nClusters uint, nLocal uint, maxNeighbors uint, calcDistance DistanceFunc, opts ...Option) Kmeans {
+	o := newOptions(opts)
+	if calcDistance == nil {
+		calcDistance = calcL2Distance
+	}
+	return &claransKMedoids{
+		nClusters:    nClusters,
+		nLocal:       nLocal,
+		maxNeighbors: maxNeighbors,
+		calcDistance: calcDistance,
+		newRand:      o.newRand,
 	}
 }
 
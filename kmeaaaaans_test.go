@@ -1,9 +1,17 @@
 package kmeaaaaans
 
 import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
+	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -11,9 +19,28 @@ func TestClustering(t *testing.T) {
 	for _, kmeans := range []Kmeans{
 		NewLloydKmeans(2, 1e-8, 10, 1024, KmeansPlusPlus),
 		NewLloydKmeans(2, 1e-8, 10, 2, KmeansPlusPlus),
+		NewLloydKmeans(2, 1e-8, 10, 2, KmeansParallel),
+		NewLloydKmeans(2, 1e-8, 10, 2, GreedyKmeansPlusPlus),
+		NewElkanKmeans(2, 1e-8, 10, 1024, KmeansPlusPlus),
+		NewElkanKmeans(2, 1e-8, 10, 2, KmeansPlusPlus),
+		NewHamerlyKmeans(2, 1e-8, 10, 1024, KmeansPlusPlus),
+		NewHamerlyKmeans(2, 1e-8, 10, 2, KmeansPlusPlus),
+		NewHamerlyKmeans(2, 1e-8, 10, 2, KmeansParallel),
+		NewYinyangKmeans(2, 1e-8, 10, 1024, KmeansPlusPlus),
+		NewYinyangKmeans(2, 1e-8, 10, 2, KmeansPlusPlus),
 		NewMiniBatchKmeans(2, 1e-8, 10, 10, 1024, KmeansPlusPlus),
 		NewMiniBatchKmeans(2, 1e-8, 10, 10, 4, KmeansPlusPlus),
+		NewMiniBatchKmeans(2, 1e-8, 10, 10, 4, KmeansParallel),
+		NewMiniBatchKmeans(2, 1e-8, 10, 10, 4, GreedyKmeansPlusPlus),
+		NewBisectingKmeans(2, LargestSSE, NewLloydKmeans(2, 1e-8, 10, 1024, KmeansPlusPlus)),
+		NewBisectingKmeans(2, LargestSize, NewMiniBatchKmeans(2, 1e-8, 10, 10, 1024, KmeansPlusPlus)),
+		NewMedianKmeans(2, 1e-8, 10, 1024, KmeansPlusPlus),
+		NewMedianKmeans(2, 1e-8, 10, 2, KmeansPlusPlus),
+		NewKernelKmeans(2, 1e-8, 10, 0, 2, KmeansPlusPlus, RBFKernel(0.1)),
+		NewKernelKmeans(2, 1e-8, 10, 4, 2, KmeansPlusPlus, RBFKernel(0.1)),
+		NewStreamingKmeans(2, 0, 1e-8, 10, 1024, KmeansPlusPlus),
 	} {
+		rand.Seed(1)
 		X := mat.NewDense(8, 2, []float64{1, 1, 1, 0, 0, 1, 0, 0, 5, 5, 5, 6, 6, 5, 6, 6})
 		trained, _ := kmeans.Fit(X)
 
@@ -32,3 +59,851 @@ func TestClustering(t *testing.T) {
 		}
 	}
 }
+
+func makeBlobs(nSamples, featDim, nBlobs int) *mat.Dense {
+	X := mat.NewDense(nSamples, featDim, nil)
+	for i := 0; i < nSamples; i++ {
+		center := float64(i % nBlobs)
+		for j := 0; j < featDim; j++ {
+			X.Set(i, j, 10*center*float64(j%2)+rand.NormFloat64())
+		}
+	}
+	return X
+}
+
+func TestAcceleratedLloydMatchesLloyd(t *testing.T) {
+	X := makeBlobs(1024, 3, 8)
+	for name, kmeans := range map[string]Kmeans{
+		"elkan":   NewElkanKmeans(48, 1e-8, 100, 64, KmeansPlusPlus),
+		"hamerly": NewHamerlyKmeans(48, 1e-8, 100, 64, KmeansPlusPlus),
+		"yinyang": NewYinyangKmeans(48, 1e-8, 100, 64, KmeansPlusPlus),
+	} {
+		rand.Seed(42)
+		expected, _ := NewLloydKmeans(48, 1e-8, 100, 64, KmeansPlusPlus).Fit(X)
+		rand.Seed(42)
+		trained, _ := kmeans.Fit(X)
+		if !mat.Equal(trained.Centroids(), expected.Centroids()) {
+			t.Errorf("%s: trained.Centroids() = %v, want %v", name, trained.Centroids(), expected.Centroids())
+		}
+	}
+}
+
+func TestBisectingTree(t *testing.T) {
+	rand.Seed(1)
+	X := mat.NewDense(12, 2, []float64{0, 0, 0, 1, 1, 0, 1, 1, 20, 20, 20, 21, 21, 20, 21, 21, 40, 0, 40, 1, 41, 0, 41, 1})
+	trained, err := NewBisectingKmeans(3, LargestSSE, NewLloydKmeans(2, 1e-8, 10, 1024, KmeansPlusPlus)).Fit(X)
+	if err != nil {
+		t.Fatalf("Fit(X) returned error: %v", err)
+	}
+
+	tree := trained.(TrainedBisectingKmeans).Tree()
+	if tree.NSamples != 12 || tree.SplitOrder != 0 || len(tree.Children) != 2 {
+		t.Errorf("Tree() = %+v, want root of 12 samples split first", tree)
+	}
+	if nClusters, _ := trained.Centroids().Dims(); nClusters != 3 {
+		t.Errorf("trained.Centroids() has %d rows, want 3", nClusters)
+	}
+	for nClusters := 1; nClusters <= 3; nClusters++ {
+		if n, _ := trained.(TrainedBisectingKmeans).Cut(uint(nClusters)).Centroids().Dims(); n != nClusters {
+			t.Errorf("Cut(%d).Centroids() has %d rows, want %d", nClusters, n, nClusters)
+		}
+	}
+
+	expected := trained.(TrainedBisectingKmeans).Cut(2).Centroids()
+	tree.Centroid[0] = 1000
+	tree.Children[0].Centroid[0] = 1000
+	tree.Children = nil
+	if cut := trained.(TrainedBisectingKmeans).Cut(2).Centroids(); !mat.Equal(cut, expected) {
+		t.Errorf("Cut(2).Centroids() after changing Tree() = %v, want %v", cut, expected)
+	}
+}
+
+func TestKMedoids(t *testing.T) {
+	X := mat.NewDense(6, 1, []float64{0, 1, 2, 10, 11, 12})
+	for _, kmedoids := range []Kmeans{
+		NewKMedoids(2, 10, 2, nil),
+		NewCLARA(2, 4, 0, 10, nil),
+		NewCLARANS(2, 4, 0, nil),
+	} {
+		trained, err := kmedoids.Fit(X)
+		if err != nil {
+			t.Fatalf("Fit(X) returned error: %v", err)
+		}
+		medoids := trained.(TrainedKMedoids).Medoids()
+		if !reflect.DeepEqual(medoids, []uint{4, 1}) && !reflect.DeepEqual(medoids, []uint{1, 4}) {
+			t.Errorf("Medoids() = %v, want %v", medoids, []uint{1, 4})
+		}
+	}
+
+	trained, _ := NewKMedoids(2, 10, 2, nil).Fit(X)
+	medoids := trained.(TrainedKMedoids).Medoids()
+
+	D := mat.NewDense(6, 6, nil)
+	for i := 0; i < 6; i++ {
+		for j := 0; j < 6; j++ {
+			D.Set(i, j, math.Abs(X.At(i, 0)-X.At(j, 0)))
+		}
+	}
+	trainedD, err := NewKMedoids(2, 10, 2, nil).FitDissimilarity(D)
+	if err != nil {
+		t.Fatalf("FitDissimilarity(D) returned error: %v", err)
+	}
+	if !reflect.DeepEqual(trainedD.Medoids(), medoids) {
+		t.Errorf("FitDissimilarity(D).Medoids() = %v, want %v", trainedD.Medoids(), medoids)
+	}
+	if classes := trainedD.Predict(D); !reflect.DeepEqual(classes, trained.Predict(X)) {
+		t.Errorf("FitDissimilarity(D).Predict(D) = %v, want %v", classes, trained.Predict(X))
+	}
+}
+
+func TestMedianKmeansIgnoresOutliers(t *testing.T) {
+	// The outlier 20 stays in the cluster of 0 to 3, where it would pull the
+	// mean to 5.2 while the median stays at 2.
+	X := mat.NewDense(10, 1, []float64{0, 1, 2, 3, 20, 50, 51, 52, 53, 54})
+	expect0 := mat.NewDense(2, 1, []float64{2, 52})
+	expect1 := mat.NewDense(2, 1, []float64{52, 2})
+	for seed := int64(0); seed < 20; seed++ {
+		trained, err := NewMedianKmeans(2, 1e-8, 10, 1024, KmeansPlusPlus, WithSeed(seed)).Fit(X)
+		if err != nil {
+			t.Fatalf("Fit(X) returned error: %v", err)
+		}
+
+		centroids := trained.Centroids()
+		if !mat.Equal(centroids, expect0) && !mat.Equal(centroids, expect1) {
+			t.Errorf("seed %d: trained.Centroids() = %v, want %v", seed, centroids, expect0)
+		}
+	}
+}
+
+func TestSphericalKmeans(t *testing.T) {
+	rand.Seed(1)
+	X := mat.NewDense(8, 2, []float64{1, 0.1, 2, 0.2, 10, 0.9, 5, 0.4, 0.1, 1, 0.2, 3, 0.9, 10, 0.4, 5})
+	trained, _ := NewSphericalKmeans(2, 1e-8, 10, 2, KmeansPlusPlus).Fit(X)
+
+	centroids := trained.Centroids()
+	for i := 0; i < 2; i++ {
+		if norm := mat.Norm(centroids.RowView(i), 2); math.Abs(norm-1.0) > 1e-8 {
+			t.Errorf("norm of trained.Centroids() row %d = %v, want 1", i, norm)
+		}
+	}
+
+	classes := trained.Predict(X)
+	expect0 := []uint{0, 0, 0, 0, 1, 1, 1, 1}
+	expect1 := []uint{1, 1, 1, 1, 0, 0, 0, 0}
+	if !reflect.DeepEqual(classes, expect0) && !reflect.DeepEqual(classes, expect1) {
+		t.Errorf("trained.Predict(X) = %v, want %v", classes, expect0)
+	}
+}
+
+func makeRings(nSamples int, radii []float64) (*mat.Dense, []uint) {
+	X := mat.NewDense(nSamples, 2, nil)
+	labels := make([]uint, nSamples)
+	for i := 0; i < nSamples; i++ {
+		labels[i] = uint(i % len(radii))
+		theta := 2 * math.Pi * rand.Float64()
+		r := radii[labels[i]] + 0.1*rand.NormFloat64()
+		X.Set(i, 0, r*math.Cos(theta))
+		X.Set(i, 1, r*math.Sin(theta))
+	}
+	return X, labels
+}
+
+func TestKernelKmeansSeparatesRings(t *testing.T) {
+	for _, nComponents := range []uint{0, 64} {
+		rand.Seed(1)
+		X, _ := makeRings(200, []float64{1, 5})
+		trained, err := NewKernelKmeans(2, 1e-8, 100, nComponents, 16, KmeansPlusPlus, RBFKernel(0.5), WithSeed(1)).Fit(X)
+		if err != nil {
+			t.Fatalf("Fit(X) returned error: %v", err)
+		}
+
+		Y, expected := makeRings(50, []float64{1, 5})
+		classes := trained.Predict(Y)
+		nMatched := 0
+		for i := range classes {
+			if (classes[i] == classes[0]) == (expected[i] == expected[0]) {
+				nMatched++
+			}
+		}
+		if nMatched != len(classes) {
+			t.Errorf("nComponents=%d: trained.Predict(Y) matched %d of %d ring labels", nComponents, nMatched, len(classes))
+		}
+	}
+}
+
+func TestFuzzyCMeansMembership(t *testing.T) {
+	rand.Seed(1)
+	X := mat.NewDense(8, 2, []float64{1, 1, 1, 0, 0, 1, 0, 0, 5, 5, 5, 6, 6, 5, 6, 6})
+	trained, err := NewFuzzyCMeans(2, 2.0, 1e-8, 100, 2, KmeansPlusPlus).Fit(X)
+	if err != nil {
+		t.Fatalf("Fit(X) returned error: %v", err)
+	}
+
+	Y := mat.NewDense(3, 2, []float64{0.5, 0.5, 3, 3, 5.5, 5.5})
+	membership := trained.(TrainedFuzzyCMeans).Membership(Y)
+	for i := 0; i < 3; i++ {
+		if sum := mat.Sum(membership.RowView(i)); math.Abs(sum-1.0) > 1e-8 {
+			t.Errorf("sum of Membership(Y) row %d = %v, want 1", i, sum)
+		}
+	}
+	if u := membership.At(1, 0); math.Abs(u-0.5) > 1e-2 {
+		t.Errorf("Membership(Y) of the midpoint = %v, want 0.5", u)
+	}
+	if u := math.Max(membership.At(0, 0), membership.At(0, 1)); u < 0.99 {
+		t.Errorf("Membership(Y) of a centroid = %v, want 1", u)
+	}
+
+	if _, err := NewFuzzyCMeans(2, 1.0, 1e-8, 100, 2, KmeansPlusPlus).Fit(X); err == nil {
+		t.Errorf("Fit(X) with fuzzifier 1 returned no error")
+	}
+}
+
+func TestFitWeighted(t *testing.T) {
+	X := mat.NewDense(4, 1, []float64{0, 10, 100, 104})
+	weights := []float64{3, 1, 1, 3}
+	for _, kmeans := range []WeightedKmeans{
+		NewLloydKmeans(2, 1e-8, 10, 2, KmeansPlusPlus).(WeightedKmeans),
+		NewHamerlyKmeans(2, 1e-8, 10, 2, KmeansPlusPlus).(WeightedKmeans),
+		NewMiniBatchKmeans(2, 1e-8, 10, 10, 4, KmeansPlusPlus).(WeightedKmeans),
+	} {
+		rand.Seed(1)
+		trained, err := kmeans.FitWeighted(X, weights)
+		if err != nil {
+			t.Fatalf("FitWeighted(X, weights) returned error: %v", err)
+		}
+
+		centroids := trained.Centroids()
+		expect0 := mat.NewDense(2, 1, []float64{2.5, 103})
+		expect1 := mat.NewDense(2, 1, []float64{103, 2.5})
+		if !mat.EqualApprox(centroids, expect0, 1e-8) && !mat.EqualApprox(centroids, expect1, 1e-8) {
+			t.Errorf("trained.Centroids() = %v, want %v", centroids, expect0)
+		}
+
+		if _, err := kmeans.FitWeighted(X, []float64{1, 1}); err == nil {
+			t.Errorf("FitWeighted(X, weights) with too few weights returned no error")
+		}
+	}
+}
+
+func TestFitWeightedNeverSeedsZeroWeights(t *testing.T) {
+	X := mat.NewDense(7, 1, []float64{0, 1, 2, 10, 11, 12, 1000})
+	weights := []float64{1, 1, 1, 1, 1, 1, 0}
+	for _, initAlgorithm := range []InitAlgorithm{KmeansPlusPlus, KmeansParallel, GreedyKmeansPlusPlus} {
+		for seed := int64(0); seed < 50; seed++ {
+			kmeans := NewLloydKmeans(2, 1e-8, 10, 2, initAlgorithm, WithSeed(seed)).(WeightedKmeans)
+			trained, err := kmeans.FitWeighted(X, weights)
+			if err != nil {
+				t.Fatalf("FitWeighted(X, weights) returned error: %v", err)
+			}
+			if centroids := trained.Centroids(); 100 < mat.Max(centroids) {
+				t.Errorf("init algorithm %d with seed %d: trained.Centroids() = %v, want no centroid on the zero weight sample", initAlgorithm, seed, centroids.RawMatrix().Data)
+			}
+		}
+	}
+}
+
+func TestBalancedKmeans(t *testing.T) {
+	rand.Seed(1)
+	X := mat.NewDense(10, 1, []float64{0, 1, 2, 3, 4, 5, 6, 7, 100, 101})
+	trained, err := NewBalancedKmeans(2, 4, 6, 1e-8, 10, 1024, KmeansPlusPlus).Fit(X)
+	if err != nil {
+		t.Fatalf("Fit(X) returned error: %v", err)
+	}
+
+	centroids := trained.Centroids()
+	expect0 := mat.NewDense(2, 1, []float64{2.5, 53.5})
+	expect1 := mat.NewDense(2, 1, []float64{53.5, 2.5})
+	if !mat.EqualApprox(centroids, expect0, 1e-8) && !mat.EqualApprox(centroids, expect1, 1e-8) {
+		t.Errorf("trained.Centroids() = %v, want %v", centroids, expect0)
+	}
+
+	if _, err := NewBalancedKmeans(2, 6, 0, 1e-8, 10, 1024, KmeansPlusPlus).Fit(X); err == nil {
+		t.Errorf("Fit(X) with infeasible sizes returned no error")
+	}
+}
+
+func TestCOPKmeans(t *testing.T) {
+	rand.Seed(1)
+	X := mat.NewDense(6, 1, []float64{0, 1, 2, 10, 11, 12})
+	mustLink := []IndexPair{{I: 0, J: 1}, {I: 3, J: 4}}
+	cannotLink := []IndexPair{{I: 1, J: 2}}
+	trained, err := NewCOPKmeans(2, 1e-8, 10, 1024, KmeansPlusPlus, mustLink, cannotLink).Fit(X)
+	if err != nil {
+		t.Fatalf("Fit(X) returned error: %v", err)
+	}
+
+	centroids := trained.Centroids()
+	expect0 := mat.NewDense(2, 1, []float64{0.5, 8.75})
+	expect1 := mat.NewDense(2, 1, []float64{8.75, 0.5})
+	if !mat.EqualApprox(centroids, expect0, 1e-8) && !mat.EqualApprox(centroids, expect1, 1e-8) {
+		t.Errorf("trained.Centroids() = %v, want %v", centroids, expect0)
+	}
+
+	// The nearest clusters of the must-linked pairs leave no cluster for 4
+	// and 5, so the only split is found by backtracking.
+	X = mat.NewDense(6, 1, []float64{0, 0.1, 10, 10.1, 5, 5})
+	mustLink = []IndexPair{{I: 0, J: 1}, {I: 2, J: 3}}
+	cannotLink = []IndexPair{{I: 0, J: 4}, {I: 4, J: 2}, {I: 2, J: 5}, {I: 5, J: 0}}
+	for seed := int64(0); seed < 20; seed++ {
+		trained, err := NewCOPKmeans(2, 1e-8, 10, 1024, KmeansPlusPlus, mustLink, cannotLink, WithSeed(seed)).Fit(X)
+		if err != nil {
+			t.Fatalf("Fit(X) with seed %d returned error: %v", seed, err)
+		}
+		labels := trained.Labels()
+		if labels[0] != labels[2] || labels[4] != labels[5] || labels[0] == labels[4] {
+			t.Errorf("trained.Labels() with seed %d = %v, want {0, 1, 2, 3} and {4, 5}", seed, labels)
+		}
+	}
+
+	for _, tc := range []struct {
+		mustLink   []IndexPair
+		cannotLink []IndexPair
+	}{
+		{mustLink: []IndexPair{{I: 0, J: 1}}, cannotLink: []IndexPair{{I: 1, J: 0}}},
+		{cannotLink: []IndexPair{{I: 0, J: 1}, {I: 1, J: 2}, {I: 0, J: 2}}},
+		{mustLink: []IndexPair{{I: 0, J: 6}}},
+	} {
+		if _, err := NewCOPKmeans(2, 1e-8, 10, 1024, KmeansPlusPlus, tc.mustLink, tc.cannotLink).Fit(X); err == nil {
+			t.Errorf("Fit(X) with infeasible constraints %v, %v returned no error", tc.mustLink, tc.cannotLink)
+		}
+	}
+}
+
+func TestXMeansSelectsNClusters(t *testing.T) {
+	rand.Seed(1)
+	X := makeBlobs(600, 2, 4)
+	for _, tc := range []struct {
+		minClusters uint
+		maxClusters uint
+		expected    uint
+	}{
+		{minClusters: 1, maxClusters: 10, expected: 4},
+		{minClusters: 2, maxClusters: 3, expected: 3},
+		{minClusters: 6, maxClusters: 10, expected: 6},
+	} {
+		trained, err := NewXMeans(tc.minClusters, tc.maxClusters, 1e-8, 100, 1024, KmeansPlusPlus).Fit(X)
+		if err != nil {
+			t.Fatalf("Fit(X) returned error: %v", err)
+		}
+		if nClusters := trained.(TrainedXMeans).NClusters(); nClusters != tc.expected {
+			t.Errorf("NClusters() with range [%d, %d] = %d, want %d", tc.minClusters, tc.maxClusters, nClusters, tc.expected)
+		}
+	}
+}
+
+func TestGMeansKeepsElongatedCluster(t *testing.T) {
+	rand.Seed(1)
+	blobs := makeBlobs(600, 2, 4)
+	elongated := mat.NewDense(500, 2, nil)
+	for i := 0; i < 500; i++ {
+		elongated.Set(i, 0, 10*rand.NormFloat64())
+		elongated.Set(i, 1, rand.NormFloat64())
+	}
+
+	for _, tc := range []struct {
+		X        *mat.Dense
+		expected uint
+	}{
+		{X: blobs, expected: 4},
+		{X: elongated, expected: 1},
+	} {
+		trained, err := NewGMeans(1, 10, 1e-4, 1e-8, 100, 1024, KmeansPlusPlus).Fit(tc.X)
+		if err != nil {
+			t.Fatalf("Fit(X) returned error: %v", err)
+		}
+		if nClusters := trained.(TrainedXMeans).NClusters(); nClusters != tc.expected {
+			t.Errorf("NClusters() = %d, want %d", nClusters, tc.expected)
+		}
+	}
+}
+
+type sliceRowIterator struct {
+	rows [][]float64
+}
+
+func (it *sliceRowIterator) Next() ([]float64, error) {
+	if len(it.rows) == 0 {
+		return nil, io.EOF
+	}
+	row := it.rows[0]
+	it.rows = it.rows[1:]
+	return row, nil
+}
+
+func TestStreamingKmeans(t *testing.T) {
+	rand.Seed(3)
+	nSamples := 20000
+	rows := make([][]float64, nSamples)
+	for i := range rows {
+		rows[i] = []float64{rand.NormFloat64(), 10*float64(i%4) + rand.NormFloat64()}
+	}
+
+	trained, err := NewStreamingKmeans(4, 100, 1e-8, 100, 1024, KmeansPlusPlus).FitStream(&sliceRowIterator{rows: rows})
+	if err != nil {
+		t.Fatalf("FitStream(rows) returned error: %v", err)
+	}
+
+	var centers []float64
+	centroids := trained.Centroids()
+	for i := 0; i < 4; i++ {
+		if 0.5 < math.Abs(centroids.At(i, 0)) {
+			t.Errorf("trained.Centroids() = %v, want centers on x = 0", centroids)
+		}
+		centers = append(centers, centroids.At(i, 1))
+	}
+	sort.Float64s(centers)
+	for i, center := range centers {
+		if 0.5 < math.Abs(center-10*float64(i)) {
+			t.Errorf("centers = %v, want [0 10 20 30]", centers)
+		}
+	}
+
+	if _, err := NewStreamingKmeans(4, 100, 1e-8, 100, 1024, KmeansPlusPlus).FitStream(&sliceRowIterator{rows: [][]float64{{0, 0}, {0}}}); err == nil {
+		t.Errorf("FitStream(rows) with mismatched dimensions returned no error")
+	}
+}
+
+func TestGreedyKmeansPlusPlusFindsAllBlobs(t *testing.T) {
+	rand.Seed(1)
+	X := makeBlobs(2000, 2, 8)
+	for seed := int64(0); seed < 5; seed++ {
+		rand.Seed(seed)
+		trained, _ := NewLloydKmeans(8, 1e-8, 100, 1024, GreedyKmeansPlusPlus).Fit(X)
+
+		var centers []float64
+		centroids := trained.Centroids()
+		for i := 0; i < 8; i++ {
+			centers = append(centers, centroids.At(i, 1))
+		}
+		sort.Float64s(centers)
+		for i, center := range centers {
+			if 0.5 < math.Abs(center-10*float64(i)) {
+				t.Errorf("seed %d: centers = %v, want [0 10 ... 70]", seed, centers)
+				break
+			}
+		}
+	}
+}
+
+func TestTrimmedKmeansIgnoresOutliers(t *testing.T) {
+	rand.Seed(1)
+	X := mat.NewDense(10, 1, []float64{0, 1, 2, 3, 1000, 50, 51, 52, 53, -1000})
+	trained, err := NewTrimmedKmeans(2, 0.2, 1e-8, 10, 2, KmeansPlusPlus).Fit(X)
+	if err != nil {
+		t.Fatalf("Fit(X) returned error: %v", err)
+	}
+
+	centroids := trained.Centroids()
+	expect0 := mat.NewDense(2, 1, []float64{1.5, 51.5})
+	expect1 := mat.NewDense(2, 1, []float64{51.5, 1.5})
+	if !mat.EqualApprox(centroids, expect0, 1e-8) && !mat.EqualApprox(centroids, expect1, 1e-8) {
+		t.Errorf("trained.Centroids() = %v, want %v", centroids, expect0)
+	}
+	if outliers := trained.(TrainedTrimmedKmeans).Outliers(); !reflect.DeepEqual(outliers, []uint{4, 9}) {
+		t.Errorf("Outliers() = %v, want %v", outliers, []uint{4, 9})
+	}
+
+	if _, err := NewTrimmedKmeans(2, 1.0, 1e-8, 10, 2, KmeansPlusPlus).Fit(X); err == nil {
+		t.Errorf("Fit(X) with trimming 1.0 returned no error")
+	}
+}
+
+func TestMiniBatchReassignsDeadCentroids(t *testing.T) {
+	X := mat.NewDense(100, 1, nil)
+	for i := 0; i < 100; i++ {
+		X.Set(i, 0, 100*float64(1+i%2)+0.1*float64(i%5))
+	}
+
+	for _, tc := range []struct {
+		ratio   float64
+		opts    []Option
+		hasDead bool
+	}{
+		{ratio: 0.0, hasDead: true},
+		{ratio: 0.0, opts: []Option{WithReassignmentRatio(0.0)}, hasDead: true},
+		{ratio: 0.01, opts: []Option{WithReassignmentRatio(0.01)}, hasDead: false},
+	} {
+		rand.Seed(1)
+		trained, _ := NewMiniBatchKmeans(2, 1e-8, 100, 100, 20, Random, tc.opts...).Fit(X)
+		centers := trained.Centroids().RawMatrix().Data
+		hasDead := centers[0] < 50 || centers[1] < 50
+		if hasDead != tc.hasDead {
+			t.Errorf("ratio %v: centroids = %v, want dead centroid %v", tc.ratio, centers, tc.hasDead)
+		}
+		if !hasDead && (centers[0] < 150) == (centers[1] < 150) {
+			t.Errorf("ratio %v: centroids = %v, want one near 100 and one near 200", tc.ratio, centers)
+		}
+	}
+}
+
+func TestNInitKeepsBestRun(t *testing.T) {
+	rand.Seed(1)
+	X := makeBlobs(1000, 2, 8)
+	for _, kmeans := range []Kmeans{
+		NewLloydKmeans(8, 1e-8, 100, 64, KmeansPlusPlus, WithNInit(5)),
+		NewHamerlyKmeans(8, 1e-8, 100, 64, KmeansPlusPlus, WithNInit(5)),
+		NewMiniBatchKmeans(8, 1e-8, 100, 10, 256, KmeansPlusPlus, WithNInit(5)),
+	} {
+		trained, err := kmeans.Fit(X)
+		if err != nil {
+			t.Fatalf("Fit(X) returned error: %v", err)
+		}
+
+		inertias := trained.Inertias()
+		if len(inertias) != 5 {
+			t.Fatalf("len(Inertias()) = %d, want 5", len(inertias))
+		}
+		inertia := 0.0
+		centroids := trained.Centroids()
+		for i, class := range trained.Predict(X) {
+			dist := calcL2Distance(X.RawRowView(i), centroids.RawRowView(int(class)))
+			inertia += dist * dist
+		}
+		for _, other := range inertias {
+			if other < inertia-1e-6 {
+				t.Errorf("inertia of kept run = %v, want the lowest of %v", inertia, inertias)
+				break
+			}
+		}
+	}
+
+	// The runs stop before converging, so each inertia has to describe the
+	// centroids a run returns rather than its last assignment. The first run
+	// is reproduced alone to compare with.
+	X = makeBlobs(1000, 2, 30)
+	for seed := int64(0); seed < 10; seed++ {
+		trained, err := NewLloydKmeans(30, 1e-8, 2, 64, KmeansPlusPlus, WithNInit(5), WithSeed(seed)).Fit(X)
+		if err != nil {
+			t.Fatalf("Fit(X) returned error: %v", err)
+		}
+		first, _ := NewLloydKmeans(30, 1e-8, 2, 64, KmeansPlusPlus, WithSeed(seed)).Fit(X)
+		if inertias := trained.Inertias(); math.Abs(inertias[0]-first.Inertia()) > 1e-6 {
+			t.Errorf("seed %d: Inertias()[0] = %v, want %v", seed, inertias[0], first.Inertia())
+		}
+		if first.Inertia() < trained.Inertia()-1e-6 {
+			t.Errorf("seed %d: Inertia() = %v, want at most %v", seed, trained.Inertia(), first.Inertia())
+		}
+	}
+}
+
+func TestSameSeedReproducesCentroids(t *testing.T) {
+	rand.Seed(1)
+	X := makeBlobs(500, 2, 4)
+	newKmeans := []func(opts ...Option) Kmeans{
+		func(opts ...Option) Kmeans {
+			return NewLloydKmeans(4, 1e-8, 100, 32, KmeansPlusPlus, append(opts, WithNInit(3))...)
+		},
+		func(opts ...Option) Kmeans { return NewYinyangKmeans(4, 1e-8, 100, 32, KmeansParallel, opts...) },
+		func(opts ...Option) Kmeans {
+			return NewMiniBatchKmeans(4, 1e-8, 100, 10, 64, Random, append(opts, WithNInit(2))...)
+		},
+		func(opts ...Option) Kmeans { return NewStreamingKmeans(4, 50, 1e-8, 100, 32, KmeansPlusPlus, opts...) },
+		func(opts ...Option) Kmeans { return NewXMeans(1, 8, 1e-8, 100, 32, KmeansPlusPlus, opts...) },
+		func(opts ...Option) Kmeans {
+			return NewKernelKmeans(4, 1e-8, 100, 32, 32, KmeansPlusPlus, RBFKernel(0.5), opts...)
+		},
+		func(opts ...Option) Kmeans { return NewCLARANS(4, 3, 20, nil, opts...) },
+	}
+	for i, newKmeans := range newKmeans {
+		kmeans := newKmeans(WithSeed(7))
+		trained0, err := kmeans.Fit(X)
+		if err != nil {
+			t.Fatalf("%d: Fit(X) returned error: %v", i, err)
+		}
+		trained1, _ := kmeans.Fit(X)
+		if !mat.Equal(trained0.Centroids(), trained1.Centroids()) {
+			t.Errorf("%d: centroids differ for the same seed: %v, %v", i, trained0.Centroids(), trained1.Centroids())
+		}
+
+		kmeans0 := newKmeans(WithRandSource(rand.NewSource(7)))
+		kmeans1 := newKmeans(WithRandSource(rand.NewSource(7)))
+		for run := 0; run < 2; run++ {
+			trained0, _ := kmeans0.Fit(X)
+			trained1, _ := kmeans1.Fit(X)
+			if !mat.Equal(trained0.Centroids(), trained1.Centroids()) {
+				t.Errorf("%d: centroids of fit %d differ for the same source: %v, %v", i, run, trained0.Centroids(), trained1.Centroids())
+			}
+		}
+	}
+}
+
+func TestFitContextStopsOnDeadline(t *testing.T) {
+	rand.Seed(1)
+	X := makeBlobs(2000, 2, 4)
+	// The dissimilarities of k-medoids are slowed down so that the deadline
+	// falls into their computation.
+	slowDistance := func(X, Y []float64) float64 {
+		dist := 0.0
+		for i := 0; i < 1000; i++ {
+			dist += calcL2Distance(X, Y)
+		}
+		return dist / 1000
+	}
+	for name, kmeans := range map[string]Kmeans{
+		"lloyd":      NewLloydKmeans(4, -1.0, math.MaxInt32, 64, KmeansPlusPlus, WithNInit(2)),
+		"hamerly":    NewHamerlyKmeans(4, -1.0, math.MaxInt32, 64, KmeansPlusPlus),
+		"mini-batch": NewMiniBatchKmeans(4, -1.0, math.MaxInt32, math.MaxInt32, 64, KmeansPlusPlus),
+		"median":     NewMedianKmeans(4, -1.0, math.MaxInt32, 64, KmeansPlusPlus),
+		"fuzzy":      NewFuzzyCMeans(4, 2.0, -1.0, math.MaxInt32, 64, KmeansPlusPlus),
+		"bisecting":  NewBisectingKmeans(4, LargestSSE, NewLloydKmeans(2, -1.0, math.MaxInt32, 64, KmeansPlusPlus)),
+		"streaming":  NewStreamingKmeans(4, 0, -1.0, math.MaxInt32, 64, KmeansPlusPlus),
+		"kmedoids":   NewKMedoids(4, math.MaxInt32, 1, slowDistance),
+		"kernel":     NewKernelKmeans(4, -1.0, math.MaxInt32, 0, 64, KmeansPlusPlus, RBFKernel(0.5)),
+		"clara":      NewCLARA(4, 1<<20, 0, math.MaxInt32, nil),
+		"balanced":   NewBalancedKmeans(4, 400, 600, -1.0, math.MaxInt32, 64, KmeansPlusPlus),
+		"cop":        NewCOPKmeans(4, -1.0, math.MaxInt32, 64, KmeansPlusPlus, nil, []IndexPair{{I: 0, J: 4}}),
+		"trimmed":    NewTrimmedKmeans(4, 0.1, -1.0, math.MaxInt32, 64, KmeansPlusPlus),
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		begin := time.Now()
+		trained, err := kmeans.FitContext(ctx, X)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: FitContext() returned error %v, want %v", name, err, context.DeadlineExceeded)
+		}
+		if elapsed := time.Since(begin); time.Second < elapsed {
+			t.Errorf("%s: FitContext() took %v after the deadline", name, elapsed)
+		}
+		if trained == nil {
+			t.Errorf("%s: FitContext() returned no model", name)
+			continue
+		}
+		if nClusters, _ := trained.Centroids().Dims(); name != "bisecting" && nClusters != 4 {
+			t.Errorf("%s: len(Centroids()) = %d, want 4", name, nClusters)
+		}
+	}
+}
+
+type endlessRowIterator struct{}
+
+func (it *endlessRowIterator) Next() ([]float64, error) {
+	return []float64{rand.NormFloat64(), 10*float64(rand.Intn(4)) + rand.NormFloat64()}, nil
+}
+
+func TestFitStreamContextStopsOnDeadline(t *testing.T) {
+	rand.Seed(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	trained, err := NewStreamingKmeans(4, 100, 1e-8, 100, 64, KmeansPlusPlus).FitStreamContext(ctx, &endlessRowIterator{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FitStreamContext() returned error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(begin); time.Second < elapsed {
+		t.Errorf("FitStreamContext() took %v after the deadline", elapsed)
+	}
+	if trained == nil {
+		t.Fatalf("FitStreamContext() returned no model")
+	}
+	if nClusters, _ := trained.Centroids().Dims(); nClusters != 4 {
+		t.Errorf("len(Centroids()) = %d, want 4", nClusters)
+	}
+}
+
+func TestBalancedAssignmentStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	costs := mat.NewDense(4, 2, []float64{0, 1, 1, 0, 0, 1, 1, 0})
+	classes := []uint{1, 1, 1, 1}
+	if err := assignBalancedClusters(ctx, costs, 2, 2, classes); !errors.Is(err, context.Canceled) {
+		t.Errorf("assignBalancedClusters() returned error %v, want %v", err, context.Canceled)
+	}
+	if !reflect.DeepEqual(classes, []uint{1, 1, 1, 1}) {
+		t.Errorf("classes = %v, want them untouched", classes)
+	}
+}
+
+func TestFitContextCanceledKeepsInitialCentroids(t *testing.T) {
+	X := makeBlobs(100, 2, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	trained, err := NewLloydKmeans(2, 1e-8, 100, 16, Random, WithSeed(1)).FitContext(ctx, X)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("FitContext() returned error %v, want %v", err, context.Canceled)
+	}
+	initial, _ := NewLloydKmeans(2, 1e-8, 0, 16, Random, WithSeed(1)).Fit(X)
+	if !mat.Equal(trained.Centroids(), initial.Centroids()) {
+		t.Errorf("Centroids() = %v, want the initial centroids %v", trained.Centroids(), initial.Centroids())
+	}
+}
+
+func TestObserverStopsFit(t *testing.T) {
+	rand.Seed(1)
+	X := makeBlobs(500, 2, 4)
+	var history []IterationStats
+	observer := func(stats IterationStats) bool {
+		history = append(history, stats)
+		return stats.Iteration < 3
+	}
+	trained, err := NewLloydKmeans(4, -1.0, 100, 32, Random, WithSeed(1), WithObserver(observer)).Fit(X)
+	if err != nil {
+		t.Fatalf("Fit(X) returned error: %v", err)
+	}
+
+	if len(history) != 3 {
+		t.Fatalf("observer called %d times, want 3", len(history))
+	}
+	for i, stats := range history {
+		if stats.Iteration != uint(i+1) {
+			t.Errorf("history[%d].Iteration = %d, want %d", i, stats.Iteration, i+1)
+		}
+		if 0 < i && history[i-1].Inertia < stats.Inertia {
+			t.Errorf("history[%d].Inertia = %v, want at most %v", i, stats.Inertia, history[i-1].Inertia)
+		}
+		if total := floats.Sum(stats.ClusterSizes); total != 500 {
+			t.Errorf("sum of history[%d].ClusterSizes = %v, want 500", i, total)
+		}
+	}
+	expected, _ := NewLloydKmeans(4, -1.0, 3, 32, Random, WithSeed(1)).Fit(X)
+	if !mat.Equal(trained.Centroids(), expected.Centroids()) {
+		t.Errorf("Centroids() = %v, want %v", trained.Centroids(), expected.Centroids())
+	}
+
+	nCalls := 0
+	_, err = NewMiniBatchKmeans(4, -1.0, 100, 100, 64, Random, WithNInit(3), WithObserver(func(stats IterationStats) bool {
+		nCalls++
+		return false
+	})).Fit(X)
+	if err != nil {
+		t.Fatalf("Fit(X) returned error: %v", err)
+	}
+	if nCalls != 1 {
+		t.Errorf("observer called %d times, want once for all runs", nCalls)
+	}
+
+	nCalls = 0
+	trained, err = NewXMeans(1, 8, 1e-8, 100, 32, KmeansPlusPlus, WithObserver(func(stats IterationStats) bool {
+		nCalls++
+		return false
+	})).Fit(X)
+	if err != nil {
+		t.Fatalf("Fit(X) returned error: %v", err)
+	}
+	if nClusters := trained.(TrainedXMeans).NClusters(); nCalls != 1 || nClusters != 1 {
+		t.Errorf("observer called %d times with %d clusters, want once before any split", nCalls, nClusters)
+	}
+
+	nCalls = 0
+	if _, err := NewStreamingKmeans(4, 50, 1e-8, 100, 32, KmeansPlusPlus, WithObserver(func(stats IterationStats) bool {
+		nCalls++
+		return true
+	})).Fit(X); err != nil {
+		t.Fatalf("Fit(X) returned error: %v", err)
+	}
+	if nCalls == 0 {
+		t.Errorf("observer of streaming k-means was never called")
+	}
+}
+
+func TestTrainedKmeansDescribesFit(t *testing.T) {
+	rand.Seed(1)
+	X := makeBlobs(400, 2, 4)
+	for name, kmeans := range map[string]Kmeans{
+		"lloyd":     NewLloydKmeans(4, 1e-8, 100, 32, KmeansPlusPlus, WithNInit(2)),
+		"yinyang":   NewYinyangKmeans(4, 1e-8, 100, 32, KmeansPlusPlus),
+		"median":    NewMedianKmeans(4, 1e-8, 100, 32, KmeansPlusPlus),
+		"fuzzy":     NewFuzzyCMeans(4, 2.0, 1e-8, 100, 32, KmeansPlusPlus),
+		"kmedoids":  NewKMedoids(4, 100, 32, nil),
+		"xmeans":    NewXMeans(1, 8, 1e-8, 100, 32, KmeansPlusPlus),
+		"bisecting": NewBisectingKmeans(4, LargestSSE, NewLloydKmeans(2, 1e-8, 100, 32, KmeansPlusPlus)),
+		"clara":     NewCLARA(4, 5, 0, 100, nil),
+	} {
+		trained, err := kmeans.Fit(X)
+		if err != nil {
+			t.Fatalf("%s: Fit(X) returned error: %v", name, err)
+		}
+		if !trained.Converged() || trained.NIter() == 0 {
+			t.Errorf("%s: Converged() = %v after %d iterations, want true", name, trained.Converged(), trained.NIter())
+		}
+
+		// The blobs are far apart, so that even the leaves of the bisecting
+		// tree label the samples by their nearest centroids.
+		labels := trained.Labels()
+		if !reflect.DeepEqual(labels, trained.Predict(X)) {
+			t.Errorf("%s: Labels() differs from Predict(X)", name)
+		}
+		sizes := make([]float64, len(trained.ClusterSizes()))
+		for _, label := range labels {
+			sizes[label]++
+		}
+		if !reflect.DeepEqual(sizes, trained.ClusterSizes()) {
+			t.Errorf("%s: ClusterSizes() = %v, want %v", name, trained.ClusterSizes(), sizes)
+		}
+		labels[0]++
+		if trained.Labels()[0] == labels[0] {
+			t.Errorf("%s: Labels() returned the slice of the model", name)
+		}
+		labels[0]--
+
+		calcDistance := calcSquaredL2Distance
+		switch name {
+		case "median":
+			calcDistance = calcL1Distance
+		case "kmedoids", "clara":
+			calcDistance = calcL2Distance
+		}
+		inertia := 0.0
+		centroids := trained.Centroids()
+		for i, label := range labels {
+			inertia += calcDistance(X.RawRowView(i), centroids.RawRowView(int(label)))
+		}
+		if math.Abs(inertia-trained.Inertia()) > 1e-6*inertia {
+			t.Errorf("%s: Inertia() = %v, want %v", name, trained.Inertia(), inertia)
+		}
+	}
+
+	trained, _ := NewLloydKmeans(4, 1e-8, 1, 32, KmeansPlusPlus).Fit(X)
+	if trained.Converged() || trained.NIter() != 1 {
+		t.Errorf("Converged() = %v after %d iterations, want false after 1", trained.Converged(), trained.NIter())
+	}
+
+	trained, _ = NewTrimmedKmeans(4, 0.1, 1e-8, 100, 32, KmeansPlusPlus).Fit(X)
+	isOutlier := make([]bool, 400)
+	for _, i := range trained.(TrainedTrimmedKmeans).Outliers() {
+		isOutlier[i] = true
+	}
+	inertia := 0.0
+	centroids := trained.Centroids()
+	for i, label := range trained.Labels() {
+		if !isOutlier[i] {
+			inertia += calcSquaredL2Distance(X.RawRowView(i), centroids.RawRowView(int(label)))
+		}
+	}
+	if math.Abs(inertia-trained.Inertia()) > 1e-6*inertia {
+		t.Errorf("trimmed: Inertia() = %v, want %v over the inliers", trained.Inertia(), inertia)
+	}
+	if total := floats.Sum(trained.ClusterSizes()); total != 360 {
+		t.Errorf("trimmed: sum of ClusterSizes() = %v, want 360 inliers", total)
+	}
+
+	// The inertia of kernel k-means is the sum over the clusters of the
+	// self similarities less the mean similarity within the cluster.
+	kernel := RBFKernel(0.1)
+	trained, _ = NewKernelKmeans(4, 1e-8, 100, 0, 32, KmeansPlusPlus, kernel).Fit(X)
+	labels := trained.Labels()
+	if !reflect.DeepEqual(labels, trained.Predict(X)) {
+		t.Errorf("kernel: Labels() differs from Predict(X)")
+	}
+	sizes := trained.ClusterSizes()
+	inertia = 0.0
+	for i, li := range labels {
+		inertia += kernel(X.RawRowView(i), X.RawRowView(i))
+		for j, lj := range labels {
+			if li == lj {
+				inertia -= kernel(X.RawRowView(i), X.RawRowView(j)) / sizes[li]
+			}
+		}
+	}
+	if math.Abs(inertia-trained.Inertia()) > 1e-6*inertia {
+		t.Errorf("kernel: Inertia() = %v, want %v", trained.Inertia(), inertia)
+	}
+
+	trained, _ = NewStreamingKmeans(4, 50, 1e-8, 100, 32, KmeansPlusPlus).Fit(X)
+	if trained.Labels() != nil {
+		t.Errorf("streaming: Labels() = %v, want nil for the rows of the stream", trained.Labels())
+	}
+	if total := floats.Sum(trained.ClusterSizes()); math.Abs(total-400) > 1e-8 || trained.Inertia() <= 0.0 {
+		t.Errorf("streaming: ClusterSizes() sum to %v with Inertia() %v, want the coreset of 400 rows", total, trained.Inertia())
+	}
+}
@@ -0,0 +1,351 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+
+	"github.com/panjf2000/ants/v2"
+	"gonum.org/v1/gonum/mat"
+)
+
+type KMedoids interface {
+	Kmeans
+	// FitDissimilarity fits the medoids on the square N×N dissimilarity
+	// matrix D of the training samples instead of their features. Centroids
+	// of the returned model are then the rows of D of the medoids, and its
+	// Predict expects the N_new×N dissimilarities between the new samples and
+	// the training samples.
+	FitDissimilarity(D *mat.Dense) (TrainedKMedoids, error)
+}
+
+type TrainedKMedoids interface {
+	TrainedKmeans
+	Medoids() []uint
+}
+
+type kMedoids struct {
+	nClusters     uint
+	maxIterations uint
+	chunkSize     uint
+	calcDistance  DistanceFunc
+}
+
+var _ KMedoids = (*kMedoids)(nil)
+
+type trainedKMedoids struct {
+	*trainedKmeans
+	medoids     []uint
+	precomputed bool
+}
+
+var _ TrainedKMedoids = (*trainedKMedoids)(nil)
+
+type medoidState struct {
+	dissimilarity  func(i, j int) float64
+	medoids        []int
+	nearest        []int
+	nearestDist    []float64
+	second         []int
+	secondDist     []float64
+	removalLoss    []float64
+	isMedoidSample []bool
+	nIter          uint
+	converged      bool
+}
+
+func newMedoidState(nSamples int, dissimilarity func(i, j int) float64, medoids []int) *medoidState {
+	s := &medoidState{
+		dissimilarity:  dissimilarity,
+		medoids:        medoids,
+		nearest:        make([]int, nSamples),
+		nearestDist:    make([]float64, nSamples),
+		second:         make([]int, nSamples),
+		secondDist:     make([]float64, nSamples),
+		removalLoss:    make([]float64, len(medoids)),
+		isMedoidSample: make([]bool, nSamples),
+	}
+	s.update()
+	return s
+}
+
+func (s *medoidState) update() {
+	for i := range s.isMedoidSample {
+		s.isMedoidSample[i] = false
+	}
+	for _, m := range s.medoids {
+		s.isMedoidSample[m] = true
+	}
+
+	for o := range s.nearest {
+		s.updateNearest(o)
+	}
+	s.updateRemovalLoss()
+}
+
+func (s *medoidState) updateNearest(o int) {
+	s.nearest[o], s.nearestDist[o] = -1, math.MaxFloat64
+	s.second[o], s.secondDist[o] = -1, math.MaxFloat64
+	for i, m := range s.medoids {
+		dist := s.dissimilarity(o, m)
+		if dist < s.nearestDist[o] {
+			s.second[o], s.secondDist[o] = s.nearest[o], s.nearestDist[o]
+			s.nearest[o], s.nearestDist[o] = i, dist
+		} else if dist < s.secondDist[o] {
+			s.second[o], s.secondDist[o] = i, dist
+		}
+	}
+}
+
+func (s *medoidState) updateRemovalLoss() {
+	for i := range s.removalLoss {
+		s.removalLoss[i] = 0.0
+	}
+	if len(s.medoids) == 1 {
+		return
+	}
+	for o, nearest := range s.nearest {
+		s.removalLoss[nearest] += s.secondDist[o] - s.nearestDist[o]
+	}
+}
+
+// swap replaces the medoid at index by candidate. Only the samples which had
+// the replaced medoid as their nearest or second nearest one are compared
+// with all medoids again, the others only with candidate.
+func (s *medoidState) swap(index int, candidate int) {
+	s.isMedoidSample[s.medoids[index]] = false
+	s.isMedoidSample[candidate] = true
+	s.medoids[index] = candidate
+
+	for o := range s.nearest {
+		if s.nearest[o] == index || s.second[o] == index {
+			s.updateNearest(o)
+		} else if dist := s.dissimilarity(o, candidate); dist < s.nearestDist[o] {
+			s.second[o], s.secondDist[o] = s.nearest[o], s.nearestDist[o]
+			s.nearest[o], s.nearestDist[o] = index, dist
+		} else if dist < s.secondDist[o] {
+			s.second[o], s.secondDist[o] = index, dist
+		}
+	}
+	s.updateRemovalLoss()
+}
+
+func (s *medoidState) cost() float64 {
+	cost := 0.0
+	for _, dist := range s.nearestDist {
+		cost += dist
+	}
+	return cost
+}
+
+func (s *medoidState) swapDelta(candidate int, delta []float64) (int, float64) {
+	copy(delta, s.removalLoss)
+	acc := 0.0
+	for o, nearest := range s.nearest {
+		dist := s.dissimilarity(o, candidate)
+		if len(s.medoids) == 1 {
+			acc += dist - s.nearestDist[o]
+		} else if dist < s.nearestDist[o] {
+			acc += dist - s.nearestDist[o]
+			delta[nearest] += s.nearestDist[o] - s.secondDist[o]
+		} else if dist < s.secondDist[o] {
+			delta[nearest] += dist - s.secondDist[o]
+		}
+	}
+
+	minIndex := 0
+	for i := range delta {
+		if delta[i] < delta[minIndex] {
+			minIndex = i
+		}
+	}
+	return minIndex, delta[minIndex] + acc
+}
+
+func (s *medoidState) swapDeltaWith(index int, candidate int) float64 {
+	acc := 0.0
+	for o, nearest := range s.nearest {
+		dist := s.dissimilarity(o, candidate)
+		if nearest == index {
+			acc += math.Min(dist, s.secondDist[o]) - s.nearestDist[o]
+		} else {
+			acc += math.Min(dist, s.nearestDist[o]) - s.nearestDist[o]
+		}
+	}
+	return acc
+}
+
+func buildInitialMedoids(D *mat.Dense, nClusters int) []int {
+	nSamples, _ := D.Dims()
+	nearestDist := make([]float64, nSamples)
+	for i := range nearestDist {
+		nearestDist[i] = math.MaxFloat64
+	}
+
+	medoids := make([]int, 0, nClusters)
+	isMedoidSample := make([]bool, nSamples)
+	for len(medoids) < nClusters {
+		bestSample := -1
+		bestCost := math.MaxFloat64
+		for c := 0; c < nSamples; c++ {
+			if isMedoidSample[c] {
+				continue
+			}
+			cost := 0.0
+			for o := 0; o < nSamples; o++ {
+				cost += math.Min(nearestDist[o], D.At(o, c))
+			}
+			if cost < bestCost {
+				bestCost = cost
+				bestSample = c
+			}
+		}
+
+		medoids = append(medoids, bestSample)
+		isMedoidSample[bestSample] = true
+		for o := 0; o < nSamples; o++ {
+			nearestDist[o] = math.Min(nearestDist[o], D.At(o, bestSample))
+		}
+	}
+	return medoids
+}
+
+func fasterPAM(ctx context.Context, D *mat.Dense, medoids []int, maxIterations uint) (*medoidState, error) {
+	nSamples, _ := D.Dims()
+	s := newMedoidState(nSamples, D.At, medoids)
+	delta := make([]float64, len(medoids))
+	for i := 0; i < int(maxIterations); i++ {
+		if err := ctx.Err(); err != nil {
+			return s, err
+		}
+		s.nIter++
+		nSwaps := 0
+		for candidate := 0; candidate < nSamples; candidate++ {
+			if err := ctx.Err(); err != nil {
+				return s, err
+			}
+			if s.isMedoidSample[candidate] {
+				continue
+			}
+			index, change := s.swapDelta(candidate, delta)
+			if change < 0.0 {
+				s.swap(index, candidate)
+				nSwaps++
+			}
+		}
+		if nSwaps == 0 {
+			s.converged = true
+			break
+		}
+	}
+	return s, nil
+}
+
+func (k *kMedoids) fit(ctx context.Context, D *mat.Dense) (*medoidState, error) {
+	nSamples, nCols := D.Dims()
+	if nSamples != nCols {
+		return nil, fmt.Errorf("dissimilarity matrix must be square: %d != %d", nSamples, nCols)
+	}
+	if nSamples < int(k.nClusters) {
+		return nil, fmt.Errorf("number of samples is less than number of clusters: %d < %d", nSamples, k.nClusters)
+	}
+
+	return fasterPAM(ctx, D, buildInitialMedoids(D, int(k.nClusters)), k.maxIterations)
+}
+
+func (k *kMedoids) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *kMedoids) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	nSamples, _ := X.Dims()
+	if nSamples < int(k.nClusters) {
+		return nil, fmt.Errorf("number of samples is less than number of clusters: %d < %d", nSamples, k.nClusters)
+	}
+
+	defer ants.Release()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	D, err := calcPairwise(ctx, X, X, k.calcDistance, pool, k.chunkSize)
+	if err != nil {
+		// The medoids cannot be built from an incomplete dissimilarity
+		// matrix, so evenly spaced samples stand in for them.
+		medoids := make([]int, k.nClusters)
+		for i := range medoids {
+			medoids[i] = i * nSamples / len(medoids)
+		}
+		return newTrainedKMedoids(X, &medoidState{medoids: medoids}, k.calcDistance, false), err
+	}
+	s, err := k.fit(ctx, D)
+	if s == nil {
+		return nil, err
+	}
+	return newTrainedKMedoids(X, s, k.calcDistance, false), err
+}
+
+func (k *kMedoids) FitDissimilarity(D *mat.Dense) (TrainedKMedoids, error) {
+	s, err := k.fit(context.Background(), D)
+	if err != nil {
+		return nil, err
+	}
+	return newTrainedKMedoids(D, s, nil, true), nil
+}
+
+// newTrainedKMedoids takes the labels and the inertia from s, which must
+// cover every row of X unless s only holds medoids.
+func newTrainedKMedoids(X *mat.Dense, s *medoidState, calcDistance DistanceFunc, precomputed bool) *trainedKMedoids {
+	medoids := make([]uint, len(s.medoids))
+	for i, m := range s.medoids {
+		medoids[i] = uint(m)
+	}
+	trained := &trainedKmeans{
+		centroids:    selectRows(X, medoids),
+		calcDistance: calcDistance,
+		nIter:        s.nIter,
+		converged:    s.converged,
+	}
+	if s.nearest != nil {
+		labels := make([]uint, len(s.nearest))
+		for o, nearest := range s.nearest {
+			labels[o] = uint(nearest)
+		}
+		trained.inertia = s.cost()
+		trained.labels = labels
+		trained.clusterSizes = calcClusterSizes(labels, makeSequence(uint(len(labels))), nil, len(medoids))
+	}
+
+	return &trainedKMedoids{
+		trainedKmeans: trained,
+		medoids:       medoids,
+		precomputed:   precomputed,
+	}
+}
+
+func (k *trainedKMedoids) Predict(X *mat.Dense) []uint {
+	if !k.precomputed {
+		return k.trainedKmeans.Predict(X)
+	}
+
+	classes := make([]uint, X.RawMatrix().Rows)
+	for i := range classes {
+		minDist := math.MaxFloat64
+		for j, m := range k.medoids {
+			if dist := X.At(i, int(m)); dist < minDist {
+				minDist = dist
+				classes[i] = uint(j)
+			}
+		}
+	}
+	return classes
+}
+
+func (k *trainedKMedoids) Medoids() []uint {
+	medoids := make([]uint, len(k.medoids))
+	copy(medoids, k.medoids)
+	return medoids
+}
@@ -1,10 +1,13 @@
 package kmeaaaaans
 
 import (
+	"context"
+	"math"
+	"math/rand"
 	"runtime"
-	"sync"
 
 	"github.com/panjf2000/ants/v2"
+	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -14,14 +17,37 @@ type lloydKmeans struct {
 	maxIterations uint
 	chunkSize     uint
 	initAlgorithm InitAlgorithm
+	newAssigner   func(nSamples, nClusters int, rng *rand.Rand) centroidAssigner
+	nInit         uint
+	newRand       func() *rand.Rand
+	observer      Observer
 }
 
-var _ Kmeans = (*lloydKmeans)(nil)
+var _ WeightedKmeans = (*lloydKmeans)(nil)
 
-func updateLloydCentroids(centroids, nextCentroids *mat.Dense, nSamplesInCluster []uint) {
+type centroidAssigner interface {
+	prepare(centroids, prevCentroids *mat.Dense)
+	assign(X, centroids *mat.Dense, classes []uint, indices []uint)
+}
+
+type bruteForceAssigner struct{}
+
+var _ centroidAssigner = (*bruteForceAssigner)(nil)
+
+func newBruteForceAssigner(nSamples, nClusters int, rng *rand.Rand) centroidAssigner {
+	return &bruteForceAssigner{}
+}
+
+func (a *bruteForceAssigner) prepare(centroids, prevCentroids *mat.Dense) {}
+
+func (a *bruteForceAssigner) assign(X, centroids *mat.Dense, classes []uint, indices []uint) {
+	assignCluster(X, centroids, classes, indices, nil, calcL2Distance)
+}
+
+func updateLloydCentroids(centroids, nextCentroids *mat.Dense, nSamplesInCluster []float64) {
 	for i := 0; i < len(nSamplesInCluster); i++ {
 		if 0 < nSamplesInCluster[i] {
-			scale := 1.0 / float64(nSamplesInCluster[i])
+			scale := 1.0 / nSamplesInCluster[i]
 			centroidData := nextCentroids.RawRowView(i)
 			for j := 0; j < nextCentroids.RawMatrix().Cols; j++ {
 				centroidData[j] *= scale
@@ -33,41 +59,107 @@ func updateLloydCentroids(centroids, nextCentroids *mat.Dense, nSamplesInCluster
 }
 
 func (k *lloydKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
-	nSamples, featDim := X.Dims()
-	nextCentroids := calcInitialCentroids(X, k.nClusters, k.initAlgorithm)
-	centroids := mat.NewDense(int(k.nClusters), int(featDim), nil)
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *lloydKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	return k.fitWeighted(ctx, X, nil)
+}
+
+func (k *lloydKmeans) FitWeighted(X *mat.Dense, weights []float64) (TrainedKmeans, error) {
+	return k.fitWeighted(context.Background(), X, weights)
+}
+
+func (k *lloydKmeans) fitWeighted(ctx context.Context, X *mat.Dense, weights []float64) (TrainedKmeans, error) {
+	if err := validateWeights(X, weights); err != nil {
+		return nil, err
+	}
 
 	defer ants.Release()
 	pool, err := ants.NewPool(runtime.NumCPU())
 	if err != nil {
-		return &trainedKmeans{}, nil
+		return nil, err
 	}
 	defer pool.Release()
 
+	observe := newSyncObserver(k.observer).asObserver()
+	return fitRestarts(ctx, X, weights, k.nInit, pool, k.chunkSize, k.newRand(), func(run uint, rng *rand.Rand) (*trainedKmeans, error) {
+		return k.refine(ctx, X, weights, calcInitialCentroids(ctx, X, k.nClusters, k.initAlgorithm, weights, rng), pool, rng, run, observe)
+	})
+}
+
+func (k *lloydKmeans) fitFrom(ctx context.Context, X *mat.Dense, weights []float64, initialCentroids *mat.Dense) (TrainedKmeans, error) {
+	defer ants.Release()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	observe := newSyncObserver(k.observer).asObserver()
+	return fitRestarts(ctx, X, weights, 1, pool, k.chunkSize, k.newRand(), func(run uint, rng *rand.Rand) (*trainedKmeans, error) {
+		return k.refine(ctx, X, weights, initialCentroids, pool, rng, run, observe)
+	})
+}
+
+// refine reports the inertia of its last assignment only when the last update
+// kept the centroids, and +Inf otherwise. When ctx is done, it returns the
+// centroids of the last completed iteration together with ctx.Err().
+func (k *lloydKmeans) refine(ctx context.Context, X *mat.Dense, weights []float64, initialCentroids *mat.Dense, pool *ants.Pool, rng *rand.Rand, run uint, observe Observer) (*trainedKmeans, error) {
+	nSamples, _ := X.Dims()
+	nClusters, featDim := initialCentroids.Dims()
+	nextCentroids := mat.DenseCopyOf(initialCentroids)
+	centroids := mat.NewDense(nClusters, featDim, nil)
+
 	classes := make([]uint, nSamples)
 	indices := makeSequence(uint(nSamples))
 	chunks := makeChunks(indices, k.chunkSize)
-	nSamplesInCluster := make([]uint, k.nClusters)
-	for i := 0; i < int(k.maxIterations) && k.tolerance < calcError(centroids, nextCentroids); i++ {
+	partialInertias := make([]float64, len(chunks))
+	nSamplesInCluster := make([]float64, nClusters)
+	assigner := k.newAssigner(nSamples, nClusters, rng)
+	inertia := math.Inf(1)
+	i := 0
+	for ; i < int(k.maxIterations) && k.tolerance < calcError(centroids, nextCentroids); i++ {
 		centroids, nextCentroids = nextCentroids, centroids
+		if i == 0 {
+			assigner.prepare(centroids, nil)
+		} else {
+			assigner.prepare(centroids, nextCentroids)
+		}
 
-		var wg sync.WaitGroup
-		for _, chunk := range chunks {
-			chunk := chunk
-			wg.Add(1)
-			pool.Submit(func() {
-				defer wg.Done()
-				assignCluster(X, centroids, classes, chunk, calcL2Distance)
-			})
+		if err := submitChunks(ctx, pool, chunks, func(c int, chunk []uint) {
+			assigner.assign(X, centroids, classes, chunk)
+			partialInertias[c] = calcAssignedInertia(X, centroids, classes, chunk, weights)
+		}); err != nil {
+			return &trainedKmeans{centroids: centroids, nIter: uint(i), inertia: inertia}, err
 		}
-		wg.Wait()
+		inertia = floats.Sum(partialInertias)
 
-		accumulateSamples(X, nextCentroids, nSamplesInCluster, classes, indices)
+		accumulateSamples(X, nextCentroids, nSamplesInCluster, classes, indices, weights)
 		updateLloydCentroids(centroids, nextCentroids, nSamplesInCluster)
+		if observe != nil && !observe(IterationStats{
+			Run:          run,
+			Iteration:    uint(i + 1),
+			Inertia:      inertia,
+			Shift:        calcError(centroids, nextCentroids),
+			ClusterSizes: append([]float64(nil), nSamplesInCluster...),
+		}) {
+			i++
+			break
+		}
 	}
-	centroids = nextCentroids
-
-	return &trainedKmeans{
-		centroids: centroids,
-	}, nil
+	trained := &trainedKmeans{
+		centroids: nextCentroids,
+		inertia:   math.Inf(1),
+		nIter:     uint(i),
+		converged: calcError(centroids, nextCentroids) <= k.tolerance,
+	}
+	// The last assignment still describes the returned centroids when the
+	// update kept them, which is usually the case once the fit has converged.
+	if 0 < i && mat.Equal(centroids, nextCentroids) {
+		trained.inertia = inertia
+		trained.labels = classes
+		trained.clusterSizes = nSamplesInCluster
+	}
+	return trained, nil
 }
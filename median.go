@@ -0,0 +1,112 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/panjf2000/ants/v2"
+	"gonum.org/v1/gonum/mat"
+)
+
+type medianKmeans struct {
+	nClusters     uint
+	tolerance     float64
+	maxIterations uint
+	chunkSize     uint
+	initAlgorithm InitAlgorithm
+	newRand       func() *rand.Rand
+}
+
+var _ Kmeans = (*medianKmeans)(nil)
+
+func calcMedian(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return 0.5 * (values[n/2-1] + values[n/2])
+}
+
+func updateMedianCentroid(X *mat.Dense, centroids, nextCentroids *mat.Dense, cluster int, indices []uint) {
+	if len(indices) == 0 {
+		nextCentroids.SetRow(cluster, centroids.RawRowView(cluster))
+		return
+	}
+
+	_, featDim := X.Dims()
+	values := make([]float64, len(indices))
+	centroidData := nextCentroids.RawRowView(cluster)
+	for j := 0; j < featDim; j++ {
+		for l, index := range indices {
+			values[l] = X.At(int(index), j)
+		}
+		centroidData[j] = calcMedian(values)
+	}
+}
+
+func (k *medianKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *medianKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	nSamples, featDim := X.Dims()
+	nextCentroids := calcInitialCentroids(ctx, X, k.nClusters, k.initAlgorithm, nil, k.newRand())
+	centroids := mat.NewDense(int(k.nClusters), featDim, nil)
+
+	defer ants.Release()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	classes := make([]uint, nSamples)
+	indices := makeSequence(uint(nSamples))
+	chunks := makeChunks(indices, k.chunkSize)
+	clusterIndices := make([][]uint, k.nClusters)
+	i := 0
+	for ; i < int(k.maxIterations) && k.tolerance < calcError(centroids, nextCentroids); i++ {
+		centroids, nextCentroids = nextCentroids, centroids
+
+		if err = submitChunks(ctx, pool, chunks, func(_ int, chunk []uint) {
+			assignCluster(X, centroids, classes, chunk, nil, calcL1Distance)
+		}); err != nil {
+			nextCentroids = centroids
+			break
+		}
+
+		for j := range clusterIndices {
+			clusterIndices[j] = clusterIndices[j][:0]
+		}
+		for _, index := range indices {
+			clusterIndices[classes[index]] = append(clusterIndices[classes[index]], index)
+		}
+		var wg sync.WaitGroup
+		for j := range clusterIndices {
+			j := j
+			wg.Add(1)
+			pool.Submit(func() {
+				defer wg.Done()
+				updateMedianCentroid(X, centroids, nextCentroids, j, clusterIndices[j])
+			})
+		}
+		wg.Wait()
+	}
+	converged := err == nil && calcError(centroids, nextCentroids) <= k.tolerance
+	centroids = nextCentroids
+
+	trained := &trainedKmeans{
+		centroids:    centroids,
+		calcDistance: calcL1Distance,
+		nIter:        uint(i),
+		converged:    converged,
+	}
+	if err == nil {
+		err = summarizeFit(ctx, X, nil, trained, pool, k.chunkSize)
+	}
+	return trained, err
+}
@@ -1,33 +1,39 @@
 package kmeaaaaans
 
 import (
+	"context"
 	"math"
 	"math/rand"
 	"runtime"
-	"sync"
+	"sort"
 
 	"github.com/panjf2000/ants/v2"
+	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
 )
 
 type miniBatchKmeans struct {
-	nClusters     uint
-	tolerance     float64
-	maxIterations uint
-	maxNoImprobe  uint
-	batchSize     uint
-	initAlgorithm InitAlgorithm
+	nClusters         uint
+	tolerance         float64
+	maxIterations     uint
+	maxNoImprobe      uint
+	batchSize         uint
+	initAlgorithm     InitAlgorithm
+	reassignmentRatio float64
+	nInit             uint
+	newRand           func() *rand.Rand
+	observer          Observer
 }
 
-var _ Kmeans = (*miniBatchKmeans)(nil)
+var _ WeightedKmeans = (*miniBatchKmeans)(nil)
 
-func updateMiniBatchCentroids(nextCentroids *mat.Dense, centroids *mat.Dense, nSamplesInCluster []uint, accNSamplesInCluster []uint) {
+func updateMiniBatchCentroids(nextCentroids *mat.Dense, centroids *mat.Dense, nSamplesInCluster []float64, accNSamplesInCluster []float64) {
 	for i := 0; i < len(nSamplesInCluster); i++ {
 		accNSamplesInCluster[i] += nSamplesInCluster[i]
 		if 0 < nSamplesInCluster[i] {
-			w0 := 1.0 / float64(accNSamplesInCluster[i])
+			w0 := 1.0 / accNSamplesInCluster[i]
 			nextCentroidRowData := nextCentroids.RawRowView(i)
-			w1 := w0 * float64(nSamplesInCluster[i])
+			w1 := w0 * nSamplesInCluster[i]
 			curCentroidRowData := centroids.RawRowView(i)
 			for j := 0; j < nextCentroids.RawMatrix().Cols; j++ {
 				nextCentroidRowData[j] = w0*nextCentroidRowData[j] + (1-w1)*curCentroidRowData[j]
@@ -38,53 +44,130 @@ func updateMiniBatchCentroids(nextCentroids *mat.Dense, centroids *mat.Dense, nS
 	}
 }
 
+// reassignMiniBatchCentroids reseeds the centroids whose accumulated counts
+// fall below ratio times the largest one with random samples of the batch.
+func reassignMiniBatchCentroids(X *mat.Dense, centroids *mat.Dense, accNSamplesInCluster []float64, indices []uint, ratio float64, rng *rand.Rand) {
+	maxCount := 0.0
+	for _, count := range accNSamplesInCluster {
+		maxCount = math.Max(maxCount, count)
+	}
+
+	var reassigns []int
+	minCount := math.MaxFloat64
+	for i, count := range accNSamplesInCluster {
+		if count < ratio*maxCount {
+			reassigns = append(reassigns, i)
+		} else {
+			minCount = math.Min(minCount, count)
+		}
+	}
+	if len(reassigns) == 0 {
+		return
+	}
+	if len(reassigns) == len(accNSamplesInCluster) {
+		minCount = 0.0
+	}
+	if maxReassigns := maxInt(1, len(indices)/2); maxReassigns < len(reassigns) {
+		sort.SliceStable(reassigns, func(a, b int) bool {
+			return accNSamplesInCluster[reassigns[a]] < accNSamplesInCluster[reassigns[b]]
+		})
+		reassigns = reassigns[:maxReassigns]
+	}
+
+	for l, p := range rng.Perm(len(indices))[:minInt(len(reassigns), len(indices))] {
+		centroids.SetRow(reassigns[l], X.RawRowView(int(indices[p])))
+		accNSamplesInCluster[reassigns[l]] = minCount
+	}
+}
+
+// assignMiniBatch assigns the samples of a batch and returns the weighted sums
+// of their distances and of their squared distances to the nearest centroids.
+func assignMiniBatch(X *mat.Dense, centroids *mat.Dense, classes []uint, indices []uint, weights []float64) (float64, float64) {
+	nClusters, _ := centroids.Dims()
+	inertia := 0.0
+	squaredInertia := 0.0
+	for _, i := range indices {
+		minDist := math.MaxFloat64
+		for j := 0; j < nClusters; j++ {
+			if dist := calcL2Distance(X.RawRowView(int(i)), centroids.RawRowView(j)); dist < minDist {
+				minDist = dist
+				classes[i] = uint(j)
+			}
+		}
+		weight := weightOf(weights, i)
+		inertia += weight * minDist
+		squaredInertia += weight * minDist * minDist
+	}
+	return inertia, squaredInertia
+}
+
 func (k *miniBatchKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
-	nSamples, featDim := X.Dims()
-	nextCentroids := calcInitialCentroids(X, k.nClusters, k.initAlgorithm)
-	centroids := mat.NewDense(int(k.nClusters), featDim, nil)
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *miniBatchKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	return k.fitWeighted(ctx, X, nil)
+}
+
+func (k *miniBatchKmeans) FitWeighted(X *mat.Dense, weights []float64) (TrainedKmeans, error) {
+	return k.fitWeighted(context.Background(), X, weights)
+}
+
+func (k *miniBatchKmeans) fitWeighted(ctx context.Context, X *mat.Dense, weights []float64) (TrainedKmeans, error) {
+	if err := validateWeights(X, weights); err != nil {
+		return nil, err
+	}
 
 	defer ants.Release()
 	pool, err := ants.NewPool(runtime.NumCPU())
 	if err != nil {
-		return &trainedKmeans{}, nil
+		return nil, err
 	}
 	defer pool.Release()
 
+	nSamples, _ := X.Dims()
+	chunkSize := maxUint(1, uint(nSamples+runtime.NumCPU()-1)/uint(runtime.NumCPU()))
+	observe := newSyncObserver(k.observer).asObserver()
+	return fitRestarts(ctx, X, weights, k.nInit, pool, chunkSize, k.newRand(), func(run uint, rng *rand.Rand) (*trainedKmeans, error) {
+		return k.refine(ctx, X, weights, calcInitialCentroids(ctx, X, k.nClusters, k.initAlgorithm, weights, rng), pool, rng, run, observe)
+	})
+}
+
+func (k *miniBatchKmeans) refine(ctx context.Context, X *mat.Dense, weights []float64, initialCentroids *mat.Dense, pool *ants.Pool, rng *rand.Rand, run uint, observe Observer) (*trainedKmeans, error) {
+	nSamples, featDim := X.Dims()
+	nextCentroids := mat.DenseCopyOf(initialCentroids)
+	centroids := mat.NewDense(int(k.nClusters), featDim, nil)
+
 	classes := make([]uint, X.RawMatrix().Rows)
-	accNSamplesInCluster := make([]uint, k.nClusters)
-	nSamplesInCluster := make([]uint, k.nClusters)
-	chunkSize := (k.batchSize + uint(runtime.NumCPU()) - 1) / uint(runtime.NumCPU())
+	accNSamplesInCluster := make([]float64, k.nClusters)
+	nSamplesInCluster := make([]float64, k.nClusters)
+	batchSize := minUint(k.batchSize, uint(nSamples))
+	chunkSize := (batchSize + uint(runtime.NumCPU()) - 1) / uint(runtime.NumCPU())
 	minInertia := math.MaxFloat64
 	minRuns := uint(0)
 	allIndices := makeSequence(uint(nSamples))
-	for i := 0; i < int(k.maxIterations) && k.tolerance < calcError(centroids, nextCentroids); i++ {
+	converged := false
+	i := 0
+	for ; i < int(k.maxIterations) && k.tolerance < calcError(centroids, nextCentroids); i++ {
 		centroids, nextCentroids = nextCentroids, centroids
 
-		maxIndex := uint(nSamples) / k.batchSize
-		beg := (uint(i) % maxIndex) * k.batchSize
-		end := beg + k.batchSize
+		maxIndex := uint(nSamples) / batchSize
+		beg := (uint(i) % maxIndex) * batchSize
+		end := beg + batchSize
 		if beg == 0 {
-			rand.Shuffle(len(allIndices), func(i, j int) { allIndices[i], allIndices[j] = allIndices[j], allIndices[i] })
+			rng.Shuffle(len(allIndices), func(i, j int) { allIndices[i], allIndices[j] = allIndices[j], allIndices[i] })
 		}
 		indices := allIndices[beg:end]
 		chunks := makeChunks(indices, chunkSize)
 
-		inertia := 0.0
-		var wg sync.WaitGroup
-		var mu sync.Mutex
-		for _, chunk := range chunks {
-			chunk := chunk
-			wg.Add(1)
-			pool.Submit(func() {
-				defer wg.Done()
-				partialInertia := assignCluster(X, centroids, classes, chunk, calcL2Distance)
-
-				mu.Lock()
-				defer mu.Unlock()
-				inertia += partialInertia
-			})
+		partialInertias := make([]float64, len(chunks))
+		squaredInertias := make([]float64, len(chunks))
+		if err := submitChunks(ctx, pool, chunks, func(c int, chunk []uint) {
+			partialInertias[c], squaredInertias[c] = assignMiniBatch(X, centroids, classes, chunk, weights)
+		}); err != nil {
+			return &trainedKmeans{centroids: centroids, inertia: math.Inf(1), nIter: uint(i)}, err
 		}
-		wg.Wait()
+		inertia := floats.Sum(partialInertias)
 
 		if inertia < minInertia {
 			minInertia = inertia
@@ -93,15 +176,32 @@ func (k *miniBatchKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
 			minRuns++
 		}
 		if k.maxNoImprobe < minRuns {
+			converged = true
 			break
 		}
 
-		accumulateSamples(X, nextCentroids, nSamplesInCluster, classes, indices)
+		accumulateSamples(X, nextCentroids, nSamplesInCluster, classes, indices, weights)
 		updateMiniBatchCentroids(nextCentroids, centroids, nSamplesInCluster, accNSamplesInCluster)
+		if 0.0 < k.reassignmentRatio && (i+1)%(10+int(floats.Min(accNSamplesInCluster))) == 0 {
+			reassignMiniBatchCentroids(X, nextCentroids, accNSamplesInCluster, indices, k.reassignmentRatio, rng)
+		}
+		if observe != nil && !observe(IterationStats{
+			Run:          run,
+			Iteration:    uint(i + 1),
+			Inertia:      floats.Sum(squaredInertias),
+			Shift:        calcError(centroids, nextCentroids),
+			ClusterSizes: append([]float64(nil), nSamplesInCluster...),
+		}) {
+			i++
+			break
+		}
 	}
-	centroids = nextCentroids
-
+	// The batches do not assign all samples, so the inertia is left for
+	// fitRestarts to compute.
 	return &trainedKmeans{
-		centroids: centroids,
+		centroids: nextCentroids,
+		inertia:   math.Inf(1),
+		nIter:     uint(i),
+		converged: converged || calcError(centroids, nextCentroids) <= k.tolerance,
 	}, nil
 }
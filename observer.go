@@ -0,0 +1,66 @@
+package kmeaaaaans
+
+import "sync"
+
+// IterationStats describes a finished iteration. Run identifies the restart
+// when several are fitted, and Iteration counts the iterations completed by
+// it. Inertia is the sum of squared distances to the centroids used for the
+// assignment and Shift is the change of the centroids by the update. Mini-batch
+// fits report the Inertia and the ClusterSizes of the current batch.
+type IterationStats struct {
+	Run          uint
+	Iteration    uint
+	Inertia      float64
+	Shift        float64
+	ClusterSizes []float64
+}
+
+// Observer is called after every iteration. Returning false stops the fit
+// and keeps the centroids reached so far. X-means and G-means report each
+// Lloyd refit of the whole data from its first iteration on and stop
+// splitting once stopped, while streaming k-means reports the fit of its
+// coreset.
+type Observer func(stats IterationStats) bool
+
+// syncObserver serializes the calls from concurrent restarts. Once observer
+// returns false, every later call returns false without invoking it so that
+// all restarts stop.
+type syncObserver struct {
+	mu       sync.Mutex
+	observer Observer
+	stopped  bool
+}
+
+func newSyncObserver(observer Observer) *syncObserver {
+	if observer == nil {
+		return nil
+	}
+	return &syncObserver{observer: observer}
+}
+
+func (o *syncObserver) observe(stats IterationStats) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.stopped {
+		o.stopped = !o.observer(stats)
+	}
+	return !o.stopped
+}
+
+// asObserver returns nil for a nil syncObserver, so that it can be handed to
+// fits which only observe when given an Observer.
+func (o *syncObserver) asObserver() Observer {
+	if o == nil {
+		return nil
+	}
+	return o.observe
+}
+
+func (o *syncObserver) isStopped() bool {
+	if o == nil {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.stopped
+}
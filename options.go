@@ -0,0 +1,80 @@
+package kmeaaaaans
+
+import (
+	"math/rand"
+	"sync"
+)
+
+type options struct {
+	reassignmentRatio float64
+	nInit             uint
+	newRand           func() *rand.Rand
+	observer          Observer
+}
+
+type Option func(*options)
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		nInit:   1,
+		newRand: newGlobalRand,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func newGlobalRand() *rand.Rand {
+	return rand.New(rand.NewSource(rand.Int63()))
+}
+
+// derivedRand seeds every returned generator from rng, so that nested fits
+// stay reproducible as long as they are created in a fixed order.
+func derivedRand(rng *rand.Rand) func() *rand.Rand {
+	return func() *rand.Rand {
+		return rand.New(rand.NewSource(rng.Int63()))
+	}
+}
+
+// WithReassignmentRatio turns on the reassignment of low-count mini-batch
+// centroids, which is off by default.
+func WithReassignmentRatio(ratio float64) Option {
+	return func(o *options) {
+		o.reassignmentRatio = ratio
+	}
+}
+
+func WithNInit(nInit uint) Option {
+	return func(o *options) {
+		o.nInit = nInit
+	}
+}
+
+func WithSeed(seed int64) Option {
+	return func(o *options) {
+		o.newRand = func() *rand.Rand {
+			return rand.New(rand.NewSource(seed))
+		}
+	}
+}
+
+// WithRandSource draws a single seed from source for every fit, under a lock
+// so that concurrent fits may share it. A freshly seeded source thus
+// reproduces a sequence of fits, while WithSeed repeats the same fit.
+func WithRandSource(source rand.Source) Option {
+	var mu sync.Mutex
+	return func(o *options) {
+		o.newRand = func() *rand.Rand {
+			mu.Lock()
+			defer mu.Unlock()
+			return rand.New(rand.NewSource(source.Int63()))
+		}
+	}
+}
+
+func WithObserver(observer Observer) Option {
+	return func(o *options) {
+		o.observer = observer
+	}
+}
@@ -1,11 +1,15 @@
 package kmeaaaaans
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
+	"runtime"
 	"sort"
+	"sync"
 
+	"github.com/panjf2000/ants/v2"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -14,6 +18,13 @@ type InitAlgorithm int
 const (
 	KmeansPlusPlus InitAlgorithm = iota + 1
 	Random
+	KmeansParallel
+	GreedyKmeansPlusPlus
+)
+
+const (
+	kmeansParallelRounds         = 5
+	kmeansParallelOversampleRate = 2
 )
 
 func InitAlgorithmFrom(str string) (InitAlgorithm, error) {
@@ -22,61 +33,237 @@ func InitAlgorithmFrom(str string) (InitAlgorithm, error) {
 		return KmeansPlusPlus, nil
 	case "random":
 		return Random, nil
+	case "kmeans||":
+		return KmeansParallel, nil
+	case "greedy-kmeans++":
+		return GreedyKmeansPlusPlus, nil
 	default:
 		return 0, fmt.Errorf("invalid init algorithm: %s", str)
 	}
 }
 
-func calcRandomInitialCentroids(X *mat.Dense, nClusters uint) *mat.Dense {
+func calcRandomInitialCentroids(X *mat.Dense, nClusters uint, rng *rand.Rand) *mat.Dense {
 	_, nFeatures := X.Dims()
 	centroids := mat.NewDense(int(nClusters), nFeatures, nil)
 	for i := 0; i < int(nClusters); i++ {
 		for j := 0; j < int(nFeatures); j++ {
-			centroids.Set(i, j, math.Abs(rand.NormFloat64()))
+			centroids.Set(i, j, math.Abs(rng.NormFloat64()))
 		}
 	}
 	return centroids
 }
 
-func calcKmeansPlusPlusInitialCentroids(X *mat.Dense, nClusters uint) *mat.Dense {
+// fillRandomCentroids draws the centroids from row begin on uniformly from X.
+// The seedings finish this way once ctx is done, so that a cancelled fit still
+// returns complete centroids.
+func fillRandomCentroids(X *mat.Dense, centroids *mat.Dense, begin int, rng *rand.Rand) *mat.Dense {
+	nSamples, _ := X.Dims()
+	nClusters, _ := centroids.Dims()
+	for i := begin; i < nClusters; i++ {
+		centroids.SetRow(i, X.RawRowView(rng.Intn(nSamples)))
+	}
+	return centroids
+}
+
+// sampleWeighted draws a sample in proportion to its weight, or uniformly when
+// weights is nil or sums to zero.
+func sampleWeighted(nSamples int, weights []float64, rng *rand.Rand) int {
+	if weights == nil {
+		return rng.Intn(nSamples)
+	}
+
+	accValues := make([]float64, nSamples)
+	acc := 0.0
+	for i := range accValues {
+		acc += weights[i]
+		accValues[i] = acc
+	}
+	if acc == 0.0 {
+		return rng.Intn(nSamples)
+	}
+	return sampleAccumulated(accValues, rng)
+}
+
+// calcKmeansPlusPlusInitialCentroids keeps the distance of every sample to its
+// nearest centroid so far, so that each step only measures the distances to
+// the last centroid.
+func calcKmeansPlusPlusInitialCentroids(ctx context.Context, X *mat.Dense, nClusters uint, weights []float64, rng *rand.Rand) *mat.Dense {
 	nSamples, featDim := X.Dims()
 	centroids := mat.NewDense(int(nClusters), featDim, nil)
-	centroids.SetRow(0, X.RawRowView(rand.Intn(int(nSamples))))
+	centroids.SetRow(0, X.RawRowView(sampleWeighted(nSamples, weights, rng)))
+
+	minDists := make([]float64, nSamples)
+	for j := range minDists {
+		minDists[j] = math.MaxFloat64
+	}
+	accDistances := make([]float64, nSamples)
 	for i := 1; i < int(nClusters); i++ {
-		accDistances := make([]float64, nSamples)
-		for j := 0; j < int(nSamples); j++ {
-			minDinstance := math.MaxFloat64
-			for k := 0; k < i; k++ {
-				distance := calcL2Distance(X.RawRowView(j), centroids.RawRowView(k))
-				minDinstance = math.Min(minDinstance, distance)
+		if ctx.Err() != nil {
+			return fillRandomCentroids(X, centroids, i, rng)
+		}
+
+		last := centroids.RawRowView(i - 1)
+		acc := 0.0
+		for j := range minDists {
+			minDists[j] = math.Min(minDists[j], calcSquaredL2Distance(X.RawRowView(j), last))
+			acc += weightOf(weights, uint(j)) * minDists[j]
+			accDistances[j] = acc
+		}
+
+		r := acc * rng.Float64()
+		j := sort.Search(nSamples, func(i int) bool { return accDistances[i] >= r })
+		centroids.SetRow(i, X.RawRowView(j))
+	}
+
+	return centroids
+}
+
+// calcGreedyKmeansPlusPlusInitialCentroids draws 2 + log(k) candidates by D^2
+// sampling at each step and keeps the one which lowers the potential most.
+func calcGreedyKmeansPlusPlusInitialCentroids(ctx context.Context, X *mat.Dense, nClusters uint, weights []float64, rng *rand.Rand) *mat.Dense {
+	nSamples, featDim := X.Dims()
+	nTrials := 2 + int(math.Log(float64(nClusters)))
+	centroids := mat.NewDense(int(nClusters), featDim, nil)
+
+	first := sampleWeighted(nSamples, weights, rng)
+	centroids.SetRow(0, X.RawRowView(first))
+
+	minDists := make([]float64, nSamples)
+	for i := range minDists {
+		minDists[i] = calcSquaredL2Distance(X.RawRowView(i), X.RawRowView(first))
+	}
+	accValues := make([]float64, nSamples)
+	trialDists := make([]float64, nSamples)
+	bestDists := make([]float64, nSamples)
+	for c := 1; c < int(nClusters); c++ {
+		if ctx.Err() != nil {
+			return fillRandomCentroids(X, centroids, c, rng)
+		}
+
+		acc := 0.0
+		for i, dist := range minDists {
+			acc += weightOf(weights, uint(i)) * dist
+			accValues[i] = acc
+		}
+
+		best := -1
+		bestPotential := math.MaxFloat64
+		for t := 0; t < nTrials; t++ {
+			candidate := rng.Intn(nSamples)
+			if 0.0 < acc {
+				candidate = sampleAccumulated(accValues, rng)
+			}
+
+			potential := 0.0
+			for i, dist := range minDists {
+				trialDists[i] = math.Min(dist, calcSquaredL2Distance(X.RawRowView(i), X.RawRowView(candidate)))
+				potential += weightOf(weights, uint(i)) * trialDists[i]
 			}
-			if j == 0 {
-				accDistances[j] = minDinstance
-			} else {
-				accDistances[j] = accDistances[j-1] + minDinstance
+			if potential < bestPotential {
+				bestPotential = potential
+				best = candidate
+				trialDists, bestDists = bestDists, trialDists
 			}
 		}
 
-		r := accDistances[nSamples-1] * rand.Float64()
-		j := sort.Search(int(nSamples), func(i int) bool { return accDistances[i] >= r })
-		centroids.SetRow(i, X.RawRowView(j))
+		centroids.SetRow(c, X.RawRowView(best))
+		minDists, bestDists = bestDists, minDists
 	}
 
 	return centroids
 }
 
-func calcInitialCentroids(X *mat.Dense, nClusters uint, initAlgorithm InitAlgorithm) *mat.Dense {
+// calcKmeansParallelInitialCentroids oversamples candidates in a few rounds
+// as in scalable k-means++ and reclusters the weighted candidates into
+// nClusters centroids.
+func calcKmeansParallelInitialCentroids(ctx context.Context, X *mat.Dense, nClusters uint, weights []float64, rng *rand.Rand) *mat.Dense {
+	nSamples, _ := X.Dims()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return calcKmeansPlusPlusInitialCentroids(ctx, X, nClusters, weights, rng)
+	}
+	defer pool.Release()
+
+	chunkSize := maxUint(1, uint(nSamples+runtime.NumCPU()-1)/uint(runtime.NumCPU()))
+	chunks := makeChunks(makeSequence(uint(nSamples)), chunkSize)
+	minDists := make([]float64, nSamples)
+	nearest := make([]int, nSamples)
+	for i := range minDists {
+		minDists[i] = math.MaxFloat64
+	}
+
+	candidates := []int{sampleWeighted(nSamples, weights, rng)}
+	updated := 0
+	for round := 0; ; round++ {
+		newCandidates := candidates[updated:]
+		err := submitChunks(ctx, pool, chunks, func(_ int, chunk []uint) {
+			for _, i := range chunk {
+				featData := X.RawRowView(int(i))
+				for c, candidate := range newCandidates {
+					dist := calcSquaredL2Distance(featData, X.RawRowView(candidate))
+					if dist < minDists[i] {
+						minDists[i] = dist
+						nearest[i] = updated + c
+					}
+				}
+			}
+		})
+		updated = len(candidates)
+		if err != nil || round == kmeansParallelRounds {
+			break
+		}
+
+		cost := 0.0
+		for i, dist := range minDists {
+			cost += weightOf(weights, uint(i)) * dist
+		}
+		if cost == 0.0 {
+			break
+		}
+		scale := float64(kmeansParallelOversampleRate*nClusters) / cost
+		for i, dist := range minDists {
+			if rng.Float64() < scale*weightOf(weights, uint(i))*dist {
+				candidates = append(candidates, i)
+			}
+		}
+	}
+
+	candidateWeights := make([]float64, len(candidates))
+	for i, c := range nearest {
+		candidateWeights[c] += weightOf(weights, uint(i))
+	}
+	indices := make([]uint, len(candidates))
+	for i, c := range candidates {
+		indices[i] = uint(c)
+	}
+	for len(indices) < int(nClusters) {
+		indices = append(indices, uint(rng.Intn(nSamples)))
+		candidateWeights = append(candidateWeights, 0.0)
+	}
+	return calcKmeansPlusPlusInitialCentroids(ctx, selectRows(X, indices), nClusters, candidateWeights, rng)
+}
+
+func sampleAccumulated(accValues []float64, rng *rand.Rand) int {
+	r := accValues[len(accValues)-1] * rng.Float64()
+	return minInt(sort.Search(len(accValues), func(i int) bool { return r < accValues[i] }), len(accValues)-1)
+}
+
+func calcInitialCentroids(ctx context.Context, X *mat.Dense, nClusters uint, initAlgorithm InitAlgorithm, weights []float64, rng *rand.Rand) *mat.Dense {
 	switch initAlgorithm {
 	case KmeansPlusPlus:
-		return calcKmeansPlusPlusInitialCentroids(X, nClusters)
+		return calcKmeansPlusPlusInitialCentroids(ctx, X, nClusters, weights, rng)
 	case Random:
-		return calcRandomInitialCentroids(X, nClusters)
+		return calcRandomInitialCentroids(X, nClusters, rng)
+	case KmeansParallel:
+		return calcKmeansParallelInitialCentroids(ctx, X, nClusters, weights, rng)
+	case GreedyKmeansPlusPlus:
+		return calcGreedyKmeansPlusPlusInitialCentroids(ctx, X, nClusters, weights, rng)
 	default:
 		panic("invalid init algorithm")
 	}
 }
 
-func assignCluster(X *mat.Dense, centroids *mat.Dense, classes []uint, indices []uint, calcDistance func(X, Y []float64) float64) float64 {
+func assignCluster(X *mat.Dense, centroids *mat.Dense, classes []uint, indices []uint, weights []float64, calcDistance func(X, Y []float64) float64) float64 {
 	nClusters, _ := centroids.Dims()
 	inertia := 0.0
 	for _, i := range indices {
@@ -89,14 +276,14 @@ func assignCluster(X *mat.Dense, centroids *mat.Dense, classes []uint, indices [
 				minClass = uint(j)
 			}
 		}
-		inertia += minDist
+		inertia += weightOf(weights, i) * minDist
 		classes[i] = minClass
 	}
 
 	return inertia
 }
 
-func accumulateSamples(X *mat.Dense, nextCentroids *mat.Dense, nSamplesInCluster []uint, classes []uint, indices []uint) {
+func accumulateSamples(X *mat.Dense, nextCentroids *mat.Dense, nSamplesInCluster []float64, classes []uint, indices []uint, weights []float64) {
 	nextCentroids.Zero()
 	for i := 0; i < len(nSamplesInCluster); i++ {
 		nSamplesInCluster[i] = 0
@@ -104,16 +291,221 @@ func accumulateSamples(X *mat.Dense, nextCentroids *mat.Dense, nSamplesInCluster
 
 	for _, i := range indices {
 		cluster := int(classes[i])
-		nSamplesInCluster[cluster]++
+		weight := weightOf(weights, i)
+		nSamplesInCluster[cluster] += weight
 		centroidData := nextCentroids.RawRowView(cluster)
 		featData := X.RawRowView(int(i))
 		for j := 0; j < len(featData); j++ {
-			centroidData[j] += featData[j]
+			centroidData[j] += weight * featData[j]
 		}
 	}
 }
 
+func calcPairwise(ctx context.Context, X, Y *mat.Dense, fn func(X, Y []float64) float64, pool *ants.Pool, chunkSize uint) (*mat.Dense, error) {
+	nRows, _ := X.Dims()
+	nCols, _ := Y.Dims()
+	P := mat.NewDense(nRows, nCols, nil)
+
+	err := submitChunks(ctx, pool, makeChunks(makeSequence(uint(nRows)), chunkSize), func(_ int, chunk []uint) {
+		for _, i := range chunk {
+			rowData := P.RawRowView(int(i))
+			for j := 0; j < nCols; j++ {
+				rowData[j] = fn(X.RawRowView(int(i)), Y.RawRowView(j))
+			}
+		}
+	})
+	return P, err
+}
+
+// calcAssignedInertia sums the weighted squared distances of the samples in
+// indices to the centroids they are assigned to by classes.
+func calcAssignedInertia(X *mat.Dense, centroids *mat.Dense, classes []uint, indices []uint, weights []float64) float64 {
+	inertia := 0.0
+	for _, i := range indices {
+		inertia += weightOf(weights, i) * calcSquaredL2Distance(X.RawRowView(int(i)), centroids.RawRowView(int(classes[i])))
+	}
+	return inertia
+}
+
+// summarizeFit labels every sample by its nearest centroid and records the
+// labels, cluster sizes and inertia on trained. Nothing is recorded when ctx
+// is done before all samples are labelled.
+func summarizeFit(ctx context.Context, X *mat.Dense, weights []float64, trained *trainedKmeans, pool *ants.Pool, chunkSize uint) error {
+	nSamples, _ := X.Dims()
+	calcDistance := trained.calcDistance
+	if calcDistance == nil {
+		calcDistance = calcSquaredL2Distance
+	}
+
+	labels := make([]uint, nSamples)
+	chunks := makeChunks(makeSequence(uint(nSamples)), chunkSize)
+	partials := make([]float64, len(chunks))
+	if err := submitChunks(ctx, pool, chunks, func(c int, chunk []uint) {
+		partials[c] = assignCluster(X, trained.centroids, labels, chunk, weights, calcDistance)
+	}); err != nil {
+		return err
+	}
+
+	trained.inertia = 0.0
+	for _, partial := range partials {
+		trained.inertia += partial
+	}
+	trained.labels = labels
+	trained.clusterSizes = calcClusterSizes(labels, makeSequence(uint(nSamples)), weights, trained.centroids.RawMatrix().Rows)
+	return nil
+}
+
+// summarizeLabels records labels on trained, and the cluster sizes and
+// inertia of the samples in indices.
+func summarizeLabels(X *mat.Dense, weights []float64, trained *trainedKmeans, labels []uint, indices []uint) {
+	calcDistance := trained.calcDistance
+	if calcDistance == nil {
+		calcDistance = calcSquaredL2Distance
+	}
+
+	trained.inertia = 0.0
+	for _, i := range indices {
+		trained.inertia += weightOf(weights, i) * calcDistance(X.RawRowView(int(i)), trained.centroids.RawRowView(int(labels[i])))
+	}
+	trained.labels = labels
+	trained.clusterSizes = calcClusterSizes(labels, indices, weights, trained.centroids.RawMatrix().Rows)
+}
+
+func calcClusterSizes(labels []uint, indices []uint, weights []float64, nClusters int) []float64 {
+	sizes := make([]float64, nClusters)
+	for _, i := range indices {
+		sizes[labels[i]] += weightOf(weights, i)
+	}
+	return sizes
+}
+
+// submitChunks runs fn for every chunk on the pool. It stops submitting once
+// ctx is done and returns ctx.Err() after the submitted chunks have finished.
+func submitChunks(ctx context.Context, pool *ants.Pool, chunks [][]uint, fn func(c int, chunk []uint)) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for c, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c, chunk := c, chunk
+		wg.Add(1)
+		pool.Submit(func() {
+			defer wg.Done()
+			fn(c, chunk)
+		})
+	}
+	return nil
+}
+
+// fitRestarts runs fit nInit times concurrently on the shared pool and keeps
+// the run with the lowest inertia. Each run gets its own generator derived
+// from rng. fit either returns the labels and inertia of the centroids it
+// returns, or reports an inertia of +Inf to have them summarized here, so that
+// the runs are compared by the inertia of their returned centroids. Runs
+// stopped by an error compete by whatever inertia they report, and the kept
+// centroids are then returned without statistics along with the first error.
+func fitRestarts(ctx context.Context, X *mat.Dense, weights []float64, nInit uint, pool *ants.Pool, chunkSize uint, rng *rand.Rand, fit func(run uint, rng *rand.Rand) (*trainedKmeans, error)) (*trainedKmeans, error) {
+	results := make([]*trainedKmeans, maxUint(nInit, 1))
+	errs := make([]error, len(results))
+	var wg sync.WaitGroup
+	newRand := derivedRand(rng)
+	for r := range results {
+		r := r
+		runRng := newRand()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[r], errs[r] = fit(uint(r), runRng)
+			if errs[r] == nil && math.IsInf(results[r].inertia, 1) {
+				errs[r] = summarizeFit(ctx, X, weights, results[r], pool, chunkSize)
+			}
+		}()
+	}
+	wg.Wait()
+
+	best := 0
+	for r, trained := range results {
+		if trained.inertia < results[best].inertia {
+			best = r
+		}
+	}
+	trained := results[best]
+	for _, err := range errs {
+		if err != nil {
+			trained.inertia, trained.labels, trained.clusterSizes = 0.0, nil, nil
+			return trained, err
+		}
+	}
+
+	trained.inertias = make([]float64, len(results))
+	for r, result := range results {
+		trained.inertias[r] = result.inertia
+	}
+	return trained, nil
+}
+
+func weightOf(weights []float64, i uint) float64 {
+	if weights == nil {
+		return 1.0
+	}
+	return weights[i]
+}
+
+func validateWeights(X *mat.Dense, weights []float64) error {
+	if weights == nil {
+		return nil
+	}
+
+	nSamples, _ := X.Dims()
+	if len(weights) != nSamples {
+		return fmt.Errorf("number of weights mismatch: %d != %d", len(weights), nSamples)
+	}
+	for i, w := range weights {
+		if w < 0.0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("invalid weight at %d: %v", i, w)
+		}
+	}
+	return nil
+}
+
+func selectRows(X *mat.Dense, indices []uint) *mat.Dense {
+	_, featDim := X.Dims()
+	subX := mat.NewDense(len(indices), featDim, nil)
+	for i, index := range indices {
+		subX.SetRow(i, X.RawRowView(int(index)))
+	}
+	return subX
+}
+
+func calcMeanAndSSE(X *mat.Dense, indices []uint) ([]float64, float64) {
+	_, featDim := X.Dims()
+	mean := make([]float64, featDim)
+	for _, i := range indices {
+		featData := X.RawRowView(int(i))
+		for j := 0; j < featDim; j++ {
+			mean[j] += featData[j]
+		}
+	}
+	if 0 < len(indices) {
+		scale := 1.0 / float64(len(indices))
+		for j := 0; j < featDim; j++ {
+			mean[j] *= scale
+		}
+	}
+
+	sse := 0.0
+	for _, i := range indices {
+		sse += calcSquaredL2Distance(X.RawRowView(int(i)), mean)
+	}
+	return mean, sse
+}
+
 func calcL2Distance(X, Y []float64) float64 {
+	return math.Sqrt(calcSquaredL2Distance(X, Y))
+}
+
+func calcSquaredL2Distance(X, Y []float64) float64 {
 	acc := 0.0
 	i := 0
 	for ; i < len(X)%4; i++ {
@@ -129,7 +521,31 @@ func calcL2Distance(X, Y []float64) float64 {
 		acc += diff0*diff0 + diff1*diff1 + diff2*diff2 + diff3*diff3
 	}
 
-	return math.Sqrt(acc)
+	return acc
+}
+
+func calcL1Distance(X, Y []float64) float64 {
+	acc := 0.0
+	for i := range X {
+		acc += math.Abs(X[i] - Y[i])
+	}
+	return acc
+}
+
+func calcDot(X, Y []float64) float64 {
+	acc := 0.0
+	for i := range X {
+		acc += X[i] * Y[i]
+	}
+	return acc
+}
+
+func calcCosineDistance(X, Y []float64) float64 {
+	norm := math.Sqrt(calcDot(X, X) * calcDot(Y, Y))
+	if norm == 0.0 {
+		return 1.0
+	}
+	return 1.0 - calcDot(X, Y)/norm
 }
 
 func calcError(X, Y *mat.Dense) float64 {
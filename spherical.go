@@ -0,0 +1,90 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"runtime"
+
+	"github.com/panjf2000/ants/v2"
+	"gonum.org/v1/gonum/mat"
+)
+
+type sphericalKmeans struct {
+	nClusters     uint
+	tolerance     float64
+	maxIterations uint
+	chunkSize     uint
+	initAlgorithm InitAlgorithm
+	newRand       func() *rand.Rand
+}
+
+var _ Kmeans = (*sphericalKmeans)(nil)
+
+func normalizeRows(X *mat.Dense) {
+	nSamples, _ := X.Dims()
+	for i := 0; i < nSamples; i++ {
+		rowData := X.RawRowView(i)
+		norm := math.Sqrt(calcDot(rowData, rowData))
+		if norm == 0.0 {
+			continue
+		}
+		scale := 1.0 / norm
+		for j := range rowData {
+			rowData[j] *= scale
+		}
+	}
+}
+
+func (k *sphericalKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *sphericalKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	nSamples, featDim := X.Dims()
+	normalizedX := mat.DenseCopyOf(X)
+	normalizeRows(normalizedX)
+	nextCentroids := calcInitialCentroids(ctx, normalizedX, k.nClusters, k.initAlgorithm, nil, k.newRand())
+	normalizeRows(nextCentroids)
+	centroids := mat.NewDense(int(k.nClusters), featDim, nil)
+
+	defer ants.Release()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	classes := make([]uint, nSamples)
+	indices := makeSequence(uint(nSamples))
+	chunks := makeChunks(indices, k.chunkSize)
+	nSamplesInCluster := make([]float64, k.nClusters)
+	i := 0
+	for ; i < int(k.maxIterations) && k.tolerance < calcError(centroids, nextCentroids); i++ {
+		centroids, nextCentroids = nextCentroids, centroids
+
+		if err = submitChunks(ctx, pool, chunks, func(_ int, chunk []uint) {
+			assignCluster(normalizedX, centroids, classes, chunk, nil, calcCosineDistance)
+		}); err != nil {
+			nextCentroids = centroids
+			break
+		}
+
+		accumulateSamples(normalizedX, nextCentroids, nSamplesInCluster, classes, indices, nil)
+		updateLloydCentroids(centroids, nextCentroids, nSamplesInCluster)
+		normalizeRows(nextCentroids)
+	}
+	converged := err == nil && calcError(centroids, nextCentroids) <= k.tolerance
+	centroids = nextCentroids
+
+	trained := &trainedKmeans{
+		centroids:    centroids,
+		calcDistance: calcCosineDistance,
+		nIter:        uint(i),
+		converged:    converged,
+	}
+	if err == nil {
+		err = summarizeFit(ctx, normalizedX, nil, trained, pool, k.chunkSize)
+	}
+	return trained, err
+}
@@ -0,0 +1,234 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type RowIterator interface {
+	Next() ([]float64, error)
+}
+
+type StreamingKmeans interface {
+	Kmeans
+	FitStream(rows RowIterator) (TrainedKmeans, error)
+	FitStreamContext(ctx context.Context, rows RowIterator) (TrainedKmeans, error)
+}
+
+type streamingKmeans struct {
+	nClusters     uint
+	coresetSize   uint
+	tolerance     float64
+	maxIterations uint
+	chunkSize     uint
+	initAlgorithm InitAlgorithm
+	newRand       func() *rand.Rand
+	observer      Observer
+}
+
+var _ StreamingKmeans = (*streamingKmeans)(nil)
+
+type matRowIterator struct {
+	X *mat.Dense
+	i int
+}
+
+var _ RowIterator = (*matRowIterator)(nil)
+
+type coreset struct {
+	points  *mat.Dense
+	weights []float64
+}
+
+func (it *matRowIterator) Next() ([]float64, error) {
+	nSamples, _ := it.X.Dims()
+	if nSamples <= it.i {
+		return nil, io.EOF
+	}
+	it.i++
+	return it.X.RawRowView(it.i - 1), nil
+}
+
+func mergeCoresets(coresets []*coreset, featDim int) *coreset {
+	var data []float64
+	var weights []float64
+	for _, c := range coresets {
+		data = append(data, c.points.RawMatrix().Data...)
+		weights = append(weights, c.weights...)
+	}
+	if len(weights) == 0 {
+		return &coreset{}
+	}
+	return &coreset{
+		points:  mat.NewDense(len(weights), featDim, data),
+		weights: weights,
+	}
+}
+
+// reduceCoreset picks representatives by weighted D^2 sampling and moves the
+// weight of every point onto its nearest representative.
+func reduceCoreset(c *coreset, size int, rng *rand.Rand) *coreset {
+	nPoints, featDim := c.points.Dims()
+	if nPoints <= size {
+		return c
+	}
+
+	accValues := make([]float64, nPoints)
+	acc := 0.0
+	for i, w := range c.weights {
+		acc += w
+		accValues[i] = acc
+	}
+	if acc == 0.0 {
+		return &coreset{points: selectRows(c.points, makeSequence(uint(size))), weights: make([]float64, size)}
+	}
+
+	minDists := make([]float64, nPoints)
+	nearest := make([]int, nPoints)
+	for i := range minDists {
+		minDists[i] = math.MaxFloat64
+	}
+	selected := []uint{uint(sampleAccumulated(accValues, rng))}
+	for {
+		s := len(selected) - 1
+		acc = 0.0
+		for i := 0; i < nPoints; i++ {
+			dist := calcSquaredL2Distance(c.points.RawRowView(i), c.points.RawRowView(int(selected[s])))
+			if dist < minDists[i] {
+				minDists[i] = dist
+				nearest[i] = s
+			}
+			acc += c.weights[i] * minDists[i]
+			accValues[i] = acc
+		}
+		if len(selected) == size || acc == 0.0 {
+			break
+		}
+		selected = append(selected, uint(sampleAccumulated(accValues, rng)))
+	}
+
+	weights := make([]float64, len(selected))
+	for i, s := range nearest {
+		weights[s] += c.weights[i]
+	}
+	points := mat.NewDense(len(selected), featDim, nil)
+	for s, i := range selected {
+		points.SetRow(s, c.points.RawRowView(int(i)))
+	}
+	return &coreset{points: points, weights: weights}
+}
+
+func insertCoreset(buckets []*coreset, c *coreset, size int, rng *rand.Rand) []*coreset {
+	_, featDim := c.points.Dims()
+	for level := 0; ; level++ {
+		if level == len(buckets) {
+			return append(buckets, c)
+		}
+		if buckets[level] == nil {
+			buckets[level] = c
+			return buckets
+		}
+		c = reduceCoreset(mergeCoresets([]*coreset{buckets[level], c}, featDim), size, rng)
+		buckets[level] = nil
+	}
+}
+
+func (k *streamingKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *streamingKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitStreamContext(ctx, &matRowIterator{X: X})
+}
+
+func (k *streamingKmeans) FitStream(rows RowIterator) (TrainedKmeans, error) {
+	return k.FitStreamContext(context.Background(), rows)
+}
+
+// FitStreamContext checks ctx between the rows, so a call to Next which
+// blocks is not interrupted.
+func (k *streamingKmeans) FitStreamContext(ctx context.Context, rows RowIterator) (TrainedKmeans, error) {
+	coresetSize := int(k.coresetSize)
+	if coresetSize == 0 {
+		coresetSize = 200 * int(k.nClusters)
+	}
+	coresetSize = maxInt(coresetSize, int(k.nClusters))
+
+	rng := k.newRand()
+	featDim := 0
+	var buffer []float64
+	var buckets []*coreset
+	flush := func() {
+		nRows := len(buffer) / featDim
+		weights := make([]float64, nRows)
+		for i := range weights {
+			weights[i] = 1.0
+		}
+		buckets = insertCoreset(buckets, &coreset{points: mat.NewDense(nRows, featDim, buffer), weights: weights}, coresetSize, rng)
+		buffer = nil
+	}
+	// Once ctx is done the rows read so far are still summarized, and the
+	// summary is fitted as far as ctx allows.
+	for ctx.Err() == nil {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if featDim == 0 {
+			featDim = len(row)
+		}
+		if len(row) != featDim || featDim == 0 {
+			return nil, fmt.Errorf("feature dimension mismatch: %d != %d", len(row), featDim)
+		}
+		buffer = append(buffer, row...)
+		if len(buffer) == coresetSize*featDim {
+			flush()
+		}
+	}
+	if 0 < len(buffer) {
+		flush()
+	}
+
+	var coresets []*coreset
+	for _, c := range buckets {
+		if c != nil {
+			coresets = append(coresets, c)
+		}
+	}
+	summary := mergeCoresets(coresets, featDim)
+	if len(summary.weights) < int(k.nClusters) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("number of samples is less than number of clusters: %d < %d", len(summary.weights), k.nClusters)
+	}
+
+	lloyd := &lloydKmeans{
+		nClusters:     k.nClusters,
+		tolerance:     k.tolerance,
+		maxIterations: k.maxIterations,
+		chunkSize:     k.chunkSize,
+		initAlgorithm: k.initAlgorithm,
+		newAssigner:   newBruteForceAssigner,
+		newRand:       derivedRand(rng),
+		observer:      k.observer,
+	}
+	trained, err := lloyd.fitWeighted(ctx, summary.points, summary.weights)
+	if trained == nil {
+		return nil, err
+	}
+
+	// The statistics describe the weighted coreset, and its labels would not
+	// match the rows of the stream.
+	trained.(*trainedKmeans).labels = nil
+	return trained, err
+}
@@ -5,7 +5,14 @@ import (
 )
 
 type trainedKmeans struct {
-	centroids *mat.Dense
+	centroids    *mat.Dense
+	calcDistance DistanceFunc
+	inertias     []float64
+	inertia      float64
+	nIter        uint
+	converged    bool
+	labels       []uint
+	clusterSizes []float64
 }
 
 var _ TrainedKmeans = (*trainedKmeans)(nil)
@@ -13,10 +20,38 @@ var _ TrainedKmeans = (*trainedKmeans)(nil)
 func (k *trainedKmeans) Predict(X *mat.Dense) []uint {
 	indices := makeSequence(uint(X.RawMatrix().Rows))
 	classes := make([]uint, X.RawMatrix().Rows)
-	assignCluster(X, k.centroids, classes, indices, calcL2Distance)
+	calcDistance := k.calcDistance
+	if calcDistance == nil {
+		calcDistance = calcL2Distance
+	}
+	assignCluster(X, k.centroids, classes, indices, nil, calcDistance)
 	return classes
 }
 
 func (k *trainedKmeans) Centroids() *mat.Dense {
 	return mat.DenseCopyOf(k.centroids)
 }
+
+func (k *trainedKmeans) Inertias() []float64 {
+	return append([]float64(nil), k.inertias...)
+}
+
+func (k *trainedKmeans) Inertia() float64 {
+	return k.inertia
+}
+
+func (k *trainedKmeans) NIter() uint {
+	return k.nIter
+}
+
+func (k *trainedKmeans) Converged() bool {
+	return k.converged
+}
+
+func (k *trainedKmeans) Labels() []uint {
+	return append([]uint(nil), k.labels...)
+}
+
+func (k *trainedKmeans) ClusterSizes() []float64 {
+	return append([]float64(nil), k.clusterSizes...)
+}
@@ -0,0 +1,160 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+
+	"github.com/panjf2000/ants/v2"
+	"gonum.org/v1/gonum/mat"
+)
+
+// TrainedTrimmedKmeans leaves the samples reported by Outliers out of
+// Inertia and ClusterSizes, while Labels still assigns them to the nearest
+// centroids.
+type TrainedTrimmedKmeans interface {
+	TrainedKmeans
+	Outliers() []uint
+}
+
+type trimmedKmeans struct {
+	nClusters     uint
+	trimming      float64
+	tolerance     float64
+	maxIterations uint
+	chunkSize     uint
+	initAlgorithm InitAlgorithm
+	newRand       func() *rand.Rand
+}
+
+var _ Kmeans = (*trimmedKmeans)(nil)
+
+type trainedTrimmedKmeans struct {
+	*trainedKmeans
+	outliers []uint
+}
+
+var _ TrainedTrimmedKmeans = (*trainedTrimmedKmeans)(nil)
+
+func assignClusterWithDistances(X *mat.Dense, centroids *mat.Dense, classes []uint, dists []float64, indices []uint) {
+	nClusters, _ := centroids.Dims()
+	for _, i := range indices {
+		dists[i] = math.MaxFloat64
+		for j := 0; j < nClusters; j++ {
+			if dist := calcL2Distance(X.RawRowView(int(i)), centroids.RawRowView(j)); dist < dists[i] {
+				dists[i] = dist
+				classes[i] = uint(j)
+			}
+		}
+	}
+}
+
+// splitTrimmed returns the samples sorted by index after dropping the nTrim
+// samples farthest from their centroids.
+func splitTrimmed(dists []float64, nTrim int) ([]uint, []uint) {
+	order := makeSequence(uint(len(dists)))
+	sort.SliceStable(order, func(a, b int) bool {
+		return dists[order[a]] < dists[order[b]]
+	})
+	inliers := order[:len(order)-nTrim]
+	outliers := order[len(order)-nTrim:]
+	sort.Slice(inliers, func(a, b int) bool { return inliers[a] < inliers[b] })
+	sort.Slice(outliers, func(a, b int) bool { return outliers[a] < outliers[b] })
+	return inliers, outliers
+}
+
+func (k *trimmedKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *trimmedKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	if k.trimming < 0.0 || 1.0 <= k.trimming {
+		return nil, fmt.Errorf("trimming fraction must be in [0, 1): %f", k.trimming)
+	}
+
+	nSamples, featDim := X.Dims()
+	nTrim := int(k.trimming * float64(nSamples))
+	if nSamples-nTrim < int(k.nClusters) {
+		return nil, fmt.Errorf("number of untrimmed samples is less than number of clusters: %d < %d", nSamples-nTrim, k.nClusters)
+	}
+
+	// Seeds are drawn without the samples farthest from the coordinate-wise
+	// median, since a seed placed on an outlier would keep it as a singleton
+	// cluster that is never trimmed.
+	median := make([]float64, featDim)
+	values := make([]float64, nSamples)
+	for j := range median {
+		for i := range values {
+			values[i] = X.At(i, j)
+		}
+		median[j] = calcMedian(values)
+	}
+	initialDists := make([]float64, nSamples)
+	for i := range initialDists {
+		initialDists[i] = calcL2Distance(X.RawRowView(i), median)
+	}
+	initialInliers, _ := splitTrimmed(initialDists, nTrim)
+	nextCentroids := calcInitialCentroids(ctx, selectRows(X, initialInliers), k.nClusters, k.initAlgorithm, nil, k.newRand())
+	centroids := mat.NewDense(int(k.nClusters), featDim, nil)
+
+	defer ants.Release()
+	pool, err := ants.NewPool(runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	classes := make([]uint, nSamples)
+	dists := make([]float64, nSamples)
+	indices := makeSequence(uint(nSamples))
+	chunks := makeChunks(indices, k.chunkSize)
+	nSamplesInCluster := make([]float64, k.nClusters)
+	assign := func(ctx context.Context, centroids *mat.Dense) error {
+		return submitChunks(ctx, pool, chunks, func(_ int, chunk []uint) {
+			assignClusterWithDistances(X, centroids, classes, dists, chunk)
+		})
+	}
+	i := 0
+	for ; i < int(k.maxIterations) && k.tolerance < calcError(centroids, nextCentroids); i++ {
+		centroids, nextCentroids = nextCentroids, centroids
+
+		if err = assign(ctx, centroids); err != nil {
+			nextCentroids = centroids
+			break
+		}
+		inliers, _ := splitTrimmed(dists, nTrim)
+		accumulateSamples(X, nextCentroids, nSamplesInCluster, classes, inliers, nil)
+		updateLloydCentroids(centroids, nextCentroids, nSamplesInCluster)
+	}
+	converged := err == nil && calcError(centroids, nextCentroids) <= k.tolerance
+	centroids = nextCentroids
+
+	// A cancelled fit reports no outliers, since they are only known after
+	// one more assignment to the returned centroids.
+	trained := &trainedKmeans{
+		centroids: centroids,
+		nIter:     uint(i),
+		converged: converged,
+	}
+	if err == nil {
+		err = assign(ctx, centroids)
+	}
+	if err != nil {
+		return &trainedTrimmedKmeans{trainedKmeans: trained}, err
+	}
+	inliers, outliers := splitTrimmed(dists, nTrim)
+	summarizeLabels(X, nil, trained, classes, inliers)
+	return &trainedTrimmedKmeans{
+		trainedKmeans: trained,
+		outliers:      outliers,
+	}, nil
+}
+
+func (k *trainedTrimmedKmeans) Outliers() []uint {
+	outliers := make([]uint, len(k.outliers))
+	copy(outliers, k.outliers)
+	return outliers
+}
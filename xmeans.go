@@ -0,0 +1,201 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type TrainedXMeans interface {
+	TrainedKmeans
+	NClusters() uint
+}
+
+type xmeansKmeans struct {
+	minClusters   uint
+	maxClusters   uint
+	tolerance     float64
+	maxIterations uint
+	chunkSize     uint
+	initAlgorithm InitAlgorithm
+	newRand       func() *rand.Rand
+	observer      Observer
+}
+
+var _ Kmeans = (*xmeansKmeans)(nil)
+
+type trainedXMeans struct {
+	*trainedKmeans
+}
+
+var _ TrainedXMeans = (*trainedXMeans)(nil)
+
+type clusterSplit struct {
+	cluster  int
+	children *mat.Dense
+	gain     float64
+}
+
+// calcBIC scores a spherical gaussian mixture with a shared variance as
+// described by Pelleg and Moore.
+func calcBIC(featDim int, sizes []int, sse float64) float64 {
+	nSamples := 0
+	for _, size := range sizes {
+		nSamples += size
+	}
+	nClusters := len(sizes)
+	if nSamples <= nClusters {
+		return math.Inf(-1)
+	}
+
+	variance := sse / float64(featDim*(nSamples-nClusters))
+	logLikelihood := -0.5 * float64(featDim*(nSamples-nClusters))
+	for _, size := range sizes {
+		n := float64(size)
+		logLikelihood += n*math.Log(n/float64(nSamples)) - 0.5*n*float64(featDim)*math.Log(2.0*math.Pi*variance)
+	}
+	nParameters := float64(nClusters * (featDim + 1))
+	return logLikelihood - 0.5*nParameters*math.Log(float64(nSamples))
+}
+
+func (k *xmeansKmeans) splitCluster(ctx context.Context, X *mat.Dense, indices []uint, rng *rand.Rand) (*mat.Dense, float64, error) {
+	if len(indices) <= 2 {
+		return nil, 0.0, nil
+	}
+
+	_, featDim := X.Dims()
+	subX := selectRows(X, indices)
+	trained, err := NewLloydKmeans(2, k.tolerance, k.maxIterations, k.chunkSize, k.initAlgorithm, WithSeed(rng.Int63())).FitContext(ctx, subX)
+	if err != nil {
+		return nil, 0.0, err
+	}
+
+	childIndices := make([][]uint, 2)
+	for i, class := range trained.Predict(subX) {
+		childIndices[class] = append(childIndices[class], uint(i))
+	}
+	if len(childIndices[0]) == 0 || len(childIndices[1]) == 0 {
+		return nil, 0.0, nil
+	}
+
+	children := mat.NewDense(2, featDim, nil)
+	childSSE := 0.0
+	for c, indices := range childIndices {
+		mean, sse := calcMeanAndSSE(subX, indices)
+		children.SetRow(c, mean)
+		childSSE += sse
+	}
+	_, parentSSE := calcMeanAndSSE(X, indices)
+
+	parentBIC := calcBIC(featDim, []int{len(indices)}, parentSSE)
+	childBIC := calcBIC(featDim, []int{len(childIndices[0]), len(childIndices[1])}, childSSE)
+	return children, childBIC - parentBIC, nil
+}
+
+// fitBySplitting repeatedly replaces clusters by the children proposed by
+// splitCluster and refits all centroids with Lloyd, preferring larger gains.
+// It stops once observer has stopped a refit. On error the last completely
+// fitted model is returned with it.
+func fitBySplitting(ctx context.Context, X *mat.Dense, lloyd *lloydKmeans, trained TrainedKmeans, maxClusters uint, observer *syncObserver, splitCluster func(X *mat.Dense, indices []uint) (*mat.Dense, float64, error)) (TrainedKmeans, error) {
+	for !observer.isStopped() {
+		centroids := trained.Centroids()
+		nClusters, featDim := centroids.Dims()
+		if int(maxClusters) <= nClusters {
+			break
+		}
+
+		clusterIndices := make([][]uint, nClusters)
+		for i, class := range trained.Predict(X) {
+			clusterIndices[class] = append(clusterIndices[class], uint(i))
+		}
+
+		var splits []clusterSplit
+		for j, indices := range clusterIndices {
+			children, gain, err := splitCluster(X, indices)
+			if err != nil {
+				return trained, err
+			}
+			if children != nil && 0.0 < gain {
+				splits = append(splits, clusterSplit{cluster: j, children: children, gain: gain})
+			}
+		}
+		if len(splits) == 0 {
+			break
+		}
+		sort.SliceStable(splits, func(a, b int) bool {
+			return splits[b].gain < splits[a].gain
+		})
+		splits = splits[:minInt(len(splits), int(maxClusters)-nClusters)]
+
+		isSplit := make([]bool, nClusters)
+		nextCentroids := mat.NewDense(nClusters+len(splits), featDim, nil)
+		for _, s := range splits {
+			isSplit[s.cluster] = true
+		}
+		row := 0
+		for j := 0; j < nClusters; j++ {
+			if !isSplit[j] {
+				nextCentroids.SetRow(row, centroids.RawRowView(j))
+				row++
+			}
+		}
+		for _, s := range splits {
+			nextCentroids.SetRow(row, s.children.RawRowView(0))
+			nextCentroids.SetRow(row+1, s.children.RawRowView(1))
+			row += 2
+		}
+
+		nextTrained, err := lloyd.fitFrom(ctx, X, nil, nextCentroids)
+		if err != nil {
+			return trained, err
+		}
+		trained = nextTrained
+	}
+
+	return trained, nil
+}
+
+func (k *xmeansKmeans) Fit(X *mat.Dense) (TrainedKmeans, error) {
+	return k.FitContext(context.Background(), X)
+}
+
+func (k *xmeansKmeans) FitContext(ctx context.Context, X *mat.Dense) (TrainedKmeans, error) {
+	if k.minClusters == 0 || k.maxClusters < k.minClusters {
+		return nil, fmt.Errorf("invalid cluster range: [%d, %d]", k.minClusters, k.maxClusters)
+	}
+
+	rng := k.newRand()
+	observer := newSyncObserver(k.observer)
+	lloyd := &lloydKmeans{
+		nClusters:     k.minClusters,
+		tolerance:     k.tolerance,
+		maxIterations: k.maxIterations,
+		chunkSize:     k.chunkSize,
+		initAlgorithm: k.initAlgorithm,
+		newAssigner:   newBruteForceAssigner,
+		newRand:       derivedRand(rng),
+		observer:      observer.asObserver(),
+	}
+	trained, err := lloyd.FitContext(ctx, X)
+	if err == nil {
+		trained, err = fitBySplitting(ctx, X, lloyd, trained, k.maxClusters, observer, func(X *mat.Dense, indices []uint) (*mat.Dense, float64, error) {
+			return k.splitCluster(ctx, X, indices, rng)
+		})
+	}
+	if trained == nil {
+		return nil, err
+	}
+
+	return &trainedXMeans{
+		trainedKmeans: trained.(*trainedKmeans),
+	}, err
+}
+
+func (k *trainedXMeans) NClusters() uint {
+	nClusters, _ := k.centroids.Dims()
+	return uint(nClusters)
+}
@@ -0,0 +1,195 @@
+package kmeaaaaans
+
+import (
+	"context"
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	yinyangClustersPerGroup = 10
+	yinyangGroupingIters    = 5
+)
+
+type yinyangAssigner struct {
+	nGroups        int
+	groups         [][]int
+	groupOfCluster []int
+	upper          []float64
+	lower          []float64
+	shifts         []float64
+	groupShifts    []float64
+	hasBounds      bool
+	rng            *rand.Rand
+}
+
+var _ centroidAssigner = (*yinyangAssigner)(nil)
+
+func newYinyangAssigner(nSamples, nClusters int, rng *rand.Rand) centroidAssigner {
+	nGroups := maxInt(1, (nClusters+yinyangClustersPerGroup-1)/yinyangClustersPerGroup)
+	return &yinyangAssigner{
+		nGroups:        nGroups,
+		groupOfCluster: make([]int, nClusters),
+		upper:          make([]float64, nSamples),
+		lower:          make([]float64, nSamples*nGroups),
+		shifts:         make([]float64, nClusters),
+		groupShifts:    make([]float64, nGroups),
+		rng:            rng,
+	}
+}
+
+func (a *yinyangAssigner) makeGroups(centroids *mat.Dense) {
+	nClusters, featDim := centroids.Dims()
+	groupCentroids := calcKmeansPlusPlusInitialCentroids(context.Background(), centroids, uint(a.nGroups), nil, a.rng)
+	nextGroupCentroids := mat.NewDense(a.nGroups, featDim, nil)
+	nClustersInGroup := make([]float64, a.nGroups)
+	classes := make([]uint, nClusters)
+	indices := makeSequence(uint(nClusters))
+	for i := 0; i < yinyangGroupingIters; i++ {
+		assignCluster(centroids, groupCentroids, classes, indices, nil, calcL2Distance)
+		accumulateSamples(centroids, nextGroupCentroids, nClustersInGroup, classes, indices, nil)
+		updateLloydCentroids(groupCentroids, nextGroupCentroids, nClustersInGroup)
+		groupCentroids, nextGroupCentroids = nextGroupCentroids, groupCentroids
+	}
+	assignCluster(centroids, groupCentroids, classes, indices, nil, calcL2Distance)
+
+	a.groups = make([][]int, a.nGroups)
+	for j, g := range classes {
+		a.groupOfCluster[j] = int(g)
+		a.groups[g] = append(a.groups[g], j)
+	}
+}
+
+func (a *yinyangAssigner) prepare(centroids, prevCentroids *mat.Dense) {
+	a.hasBounds = prevCentroids != nil
+	if !a.hasBounds {
+		a.makeGroups(centroids)
+		return
+	}
+
+	calcCentroidShifts(centroids, prevCentroids, a.shifts)
+	for g, group := range a.groups {
+		a.groupShifts[g] = 0.0
+		for _, j := range group {
+			a.groupShifts[g] = math.Max(a.groupShifts[g], a.shifts[j])
+		}
+	}
+}
+
+func (a *yinyangAssigner) assign(X, centroids *mat.Dense, classes []uint, indices []uint) {
+	if !a.hasBounds {
+		a.initBounds(X, centroids, classes, indices)
+		return
+	}
+
+	prevLower := make([]float64, a.nGroups)
+	visited := make([]bool, a.nGroups)
+	minValues := make([]float64, a.nGroups)
+	secondMinValues := make([]float64, a.nGroups)
+	minClasses := make([]int, a.nGroups)
+	for _, i := range indices {
+		featData := X.RawRowView(int(i))
+		lower := a.lower[int(i)*a.nGroups : (int(i)+1)*a.nGroups]
+		copy(prevLower, lower)
+		globalLower := math.MaxFloat64
+		for g := range lower {
+			lower[g] -= a.groupShifts[g]
+			globalLower = math.Min(globalLower, lower[g])
+		}
+
+		prevClass := int(classes[i])
+		upper := a.upper[i] + a.shifts[prevClass]
+		if upper < globalLower {
+			a.upper[i] = upper
+			continue
+		}
+		upper = calcL2Distance(featData, centroids.RawRowView(prevClass))
+		if upper < globalLower {
+			a.upper[i] = upper
+			continue
+		}
+
+		class := prevClass
+		minDist := upper
+		for g, group := range a.groups {
+			visited[g] = !(minDist < lower[g])
+			if !visited[g] {
+				continue
+			}
+
+			minValues[g] = math.MaxFloat64
+			secondMinValues[g] = math.MaxFloat64
+			minClasses[g] = -1
+			for _, j := range group {
+				if j == prevClass {
+					continue
+				}
+
+				value := prevLower[g] - a.shifts[j]
+				if !(minDist < value) {
+					value = calcL2Distance(featData, centroids.RawRowView(j))
+					if value < minDist || (value == minDist && j < class) {
+						minDist = value
+						class = j
+					}
+				}
+
+				if value < minValues[g] {
+					secondMinValues[g] = minValues[g]
+					minValues[g] = value
+					minClasses[g] = j
+				} else if value < secondMinValues[g] {
+					secondMinValues[g] = value
+				}
+			}
+		}
+
+		for g := range lower {
+			if !visited[g] {
+				continue
+			}
+			if minClasses[g] == class {
+				lower[g] = secondMinValues[g]
+			} else {
+				lower[g] = minValues[g]
+			}
+		}
+		if class != prevClass {
+			prevGroup := a.groupOfCluster[prevClass]
+			lower[prevGroup] = math.Min(lower[prevGroup], upper)
+		}
+		a.upper[i] = minDist
+		classes[i] = uint(class)
+	}
+}
+
+func (a *yinyangAssigner) initBounds(X, centroids *mat.Dense, classes []uint, indices []uint) {
+	nClusters, _ := centroids.Dims()
+	dists := make([]float64, nClusters)
+	for _, i := range indices {
+		featData := X.RawRowView(int(i))
+		minDist := math.MaxFloat64
+		minClass := 0
+		for j := 0; j < nClusters; j++ {
+			dists[j] = calcL2Distance(featData, centroids.RawRowView(j))
+			if dists[j] < minDist {
+				minDist = dists[j]
+				minClass = j
+			}
+		}
+
+		lower := a.lower[int(i)*a.nGroups : (int(i)+1)*a.nGroups]
+		for g, group := range a.groups {
+			lower[g] = math.MaxFloat64
+			for _, j := range group {
+				if j != minClass {
+					lower[g] = math.Min(lower[g], dists[j])
+				}
+			}
+		}
+		a.upper[i] = minDist
+		classes[i] = uint(minClass)
+	}
+}